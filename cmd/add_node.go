@@ -12,21 +12,24 @@ var (
 	addNodeCmdServerType   string
 	addNodeCmdPoolName     string
 	addNodeCmdTalosVersion string
+	addNodeCmdNoPreflight  bool
+	addNodeCmdOutput       string
 	addNodeCmd             = &cobra.Command{
 		Use:   "add-node [node-name]",
 		Short: "Add a new node",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := utils.NewLogger(verbose)
-			_, err := internal.AddNode(&logger, dir, internal.AddNodeOpts{
+			result, err := internal.AddNode(&logger, dir, internal.AddNodeOpts{
 				ConfigFile:   addNodeCmdConfigFile,
 				ServerType:   addNodeCmdServerType,
 				Controlplane: addNodeCmdControlplane,
 				PoolName:     addNodeCmdPoolName,
 				NodeName:     args[0],
 				TalosVersion: addNodeCmdTalosVersion,
+				NoPreflight:  addNodeCmdNoPreflight,
 			})
-			return err
+			return printResult(addNodeCmdOutput, result, err)
 		},
 	}
 )
@@ -37,4 +40,6 @@ func init() {
 	addNodeCmd.Flags().StringVar(&addNodeCmdServerType, "server-type", "cx22", "")
 	addNodeCmd.Flags().StringVar(&addNodeCmdPoolName, "pool-name", "", "")
 	addNodeCmd.Flags().StringVar(&addNodeCmdTalosVersion, "talos-version", "", "")
+	addNodeCmd.Flags().BoolVar(&addNodeCmdNoPreflight, "no-preflight", false, "")
+	addNodeCmd.Flags().StringVarP(&addNodeCmdOutput, "output", "o", "", "")
 }
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyConfigCmdConfigFile string
+	applyConfigCmdMode       string
+	applyConfigCmdDryRun     bool
+	applyConfigCmdOutput     string
+	applyConfigCmd           = &cobra.Command{
+		Use:   "apply-config [node-name...]",
+		Short: "Apply machine config to running nodes",
+		Args:  cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			result, err := internal.ApplyConfig(&logger, dir, internal.ApplyConfigOpts{
+				ConfigFile: applyConfigCmdConfigFile,
+				NodeNames:  args,
+				Mode:       applyConfigCmdMode,
+				DryRun:     applyConfigCmdDryRun,
+			})
+			return printResult(applyConfigCmdOutput, result, err)
+		},
+	}
+)
+
+func init() {
+	applyConfigCmd.Flags().StringVarP(&applyConfigCmdConfigFile, "config", "c", defaultConfigFile, "")
+	applyConfigCmd.Flags().StringVar(&applyConfigCmdMode, "mode", "auto", "")
+	applyConfigCmd.Flags().BoolVar(&applyConfigCmdDryRun, "dry-run", false, "")
+	applyConfigCmd.Flags().StringVarP(&applyConfigCmdOutput, "output", "o", "", "")
+}
@@ -10,18 +10,19 @@ var (
 	applyManifestsCmdConfigFile                     string
 	applyManifestsCmdNoHcloudCloudControllerManager bool
 	applyManifestsCmdNoHcloudCsiDriver              bool
+	applyManifestsCmdOutput                         string
 	applyManifestsCmd                               = &cobra.Command{
 		Use:   "apply-manifests",
 		Short: "Apply manifests",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := utils.NewLogger(verbose)
-			err := internal.ApplyManifests(&logger, dir, internal.ApplyManifestsOpts{
+			result, err := internal.ApplyManifests(&logger, dir, internal.ApplyManifestsOpts{
 				ConfigFile:                     applyManifestsCmdConfigFile,
 				NoHcloudCloudControllerManager: applyManifestsCmdNoHcloudCloudControllerManager,
 				NoHcloudCsiDriver:              applyManifestsCmdNoHcloudCsiDriver,
 			})
-			return err
+			return printResult(applyManifestsCmdOutput, result, err)
 		},
 	}
 )
@@ -30,4 +31,5 @@ func init() {
 	applyManifestsCmd.Flags().StringVarP(&applyManifestsCmdConfigFile, "config", "c", defaultConfigFile, "")
 	applyManifestsCmd.Flags().BoolVar(&applyManifestsCmdNoHcloudCloudControllerManager, "no-hcloud-cloud-controller-manager", false, "")
 	applyManifestsCmd.Flags().BoolVar(&applyManifestsCmdNoHcloudCsiDriver, "no-hcloud-csi-driver", false, "")
+	applyManifestsCmd.Flags().StringVarP(&applyManifestsCmdOutput, "output", "o", "", "")
 }
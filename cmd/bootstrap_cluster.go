@@ -9,38 +9,95 @@ import (
 )
 
 var (
-	bootstrapClusterCmdConfigFile                     string
-	bootstrapClusterCmdServerType                     string
-	bootstrapClusterCmdLocation                       string
-	bootstrapClusterCmdNetworkZone                    string
-	bootstrapClusterCmdNoFirewall                     bool
-	bootstrapClusterCmdNoTalosKubespan                bool
-	bootstrapClusterCmdNoHcloudCloudControllerManager bool
-	bootstrapClusterCmdNoHcloudCsiDriver              bool
-	bootstrapClusterCmdTalosVersion                   string
-	bootstrapClusterCmdKubernetesVersion              string
-	bootstrapClusterCmd                               = &cobra.Command{
+	bootstrapClusterCmdConfigFile                          string
+	bootstrapClusterCmdServerType                          string
+	bootstrapClusterCmdLocation                            string
+	bootstrapClusterCmdNetworkZone                         string
+	bootstrapClusterCmdNoFirewall                          bool
+	bootstrapClusterCmdNoPreflight                         bool
+	bootstrapClusterCmdNoTalosKubespan                     bool
+	bootstrapClusterCmdNoHcloudCloudControllerManager      bool
+	bootstrapClusterCmdNoHcloudCsiDriver                   bool
+	bootstrapClusterCmdExistingNetwork                     string
+	bootstrapClusterCmdNetworkIPRange                      string
+	bootstrapClusterCmdNetworkNodeSubnets                  []string
+	bootstrapClusterCmdPodRouting                          string
+	bootstrapClusterCmdPodSubnet                           string
+	bootstrapClusterCmdServiceSubnet                       string
+	bootstrapClusterCmdDualStack                           bool
+	bootstrapClusterCmdPodSubnet6                          string
+	bootstrapClusterCmdServiceSubnet6                      string
+	bootstrapClusterCmdCni                                 string
+	bootstrapClusterCmdCiliumKubeProxyReplacement          bool
+	bootstrapClusterCmdNatGateway                          bool
+	bootstrapClusterCmdNatGatewayType                      string
+	bootstrapClusterCmdControlplanePublicNet               string
+	bootstrapClusterCmdControlplaneImageSnapshot           string
+	bootstrapClusterCmdControlplaneLocations               []string
+	bootstrapClusterCmdBastionServerType                   string
+	bootstrapClusterCmdBastionPort                         int
+	bootstrapClusterCmdBastionSubnet                       string
+	bootstrapClusterCmdIngress                             bool
+	bootstrapClusterCmdIngressPool                         string
+	bootstrapClusterCmdIngressLoadBalancerType             string
+	bootstrapClusterCmdIngressProxyProtocol                bool
+	bootstrapClusterCmdControlplaneEndpoint                string
+	bootstrapClusterCmdControlplaneLoadBalancerType        string
+	bootstrapClusterCmdControlplaneLoadBalancerAlgorithm   string
+	bootstrapClusterCmdControlplaneLoadBalancerHealthCheck string
+	bootstrapClusterCmdTalosVersion                        string
+	bootstrapClusterCmdKubernetesVersion                   string
+	bootstrapClusterCmdOutput                              string
+	bootstrapClusterCmd                                    = &cobra.Command{
 		Use:   "bootstrap-cluster [cluster-name] [node-name]",
 		Short: "Bootstrap a new cluster",
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := utils.NewLogger(verbose)
-			err := internal.BootstrapCluster(&logger, dir, internal.BootstrapClusterOpts{
-				ConfigFile:                     bootstrapClusterCmdConfigFile,
-				ClusterName:                    args[0],
-				NodeName:                       args[1],
-				ServerType:                     bootstrapClusterCmdServerType,
-				Location:                       bootstrapClusterCmdLocation,
-				NetworkZone:                    bootstrapClusterCmdNetworkZone,
-				Token:                          os.Getenv("HCLOUD_TOKEN"),
-				NoFirewall:                     bootstrapClusterCmdNoFirewall,
-				NoTalosKubespan:                bootstrapClusterCmdNoTalosKubespan,
-				NoHcloudCloudControllerManager: bootstrapClusterCmdNoHcloudCloudControllerManager,
-				NoHcloudCsiDriver:              bootstrapClusterCmdNoHcloudCsiDriver,
-				TalosVersion:                   bootstrapClusterCmdTalosVersion,
-				KubernetesVersion:              bootstrapClusterCmdKubernetesVersion,
+			result, err := internal.BootstrapCluster(&logger, dir, internal.BootstrapClusterOpts{
+				ConfigFile:                          bootstrapClusterCmdConfigFile,
+				ClusterName:                         args[0],
+				NodeName:                            args[1],
+				ServerType:                          bootstrapClusterCmdServerType,
+				Location:                            bootstrapClusterCmdLocation,
+				NetworkZone:                         bootstrapClusterCmdNetworkZone,
+				Token:                               os.Getenv("HCLOUD_TOKEN"),
+				NoFirewall:                          bootstrapClusterCmdNoFirewall,
+				NoPreflight:                         bootstrapClusterCmdNoPreflight,
+				NoTalosKubespan:                     bootstrapClusterCmdNoTalosKubespan,
+				NoHcloudCloudControllerManager:      bootstrapClusterCmdNoHcloudCloudControllerManager,
+				NoHcloudCsiDriver:                   bootstrapClusterCmdNoHcloudCsiDriver,
+				ExistingNetwork:                     bootstrapClusterCmdExistingNetwork,
+				NetworkIPRange:                      bootstrapClusterCmdNetworkIPRange,
+				NetworkNodeSubnets:                  bootstrapClusterCmdNetworkNodeSubnets,
+				PodRouting:                          bootstrapClusterCmdPodRouting,
+				PodSubnet:                           bootstrapClusterCmdPodSubnet,
+				ServiceSubnet:                       bootstrapClusterCmdServiceSubnet,
+				DualStack:                           bootstrapClusterCmdDualStack,
+				PodSubnet6:                          bootstrapClusterCmdPodSubnet6,
+				ServiceSubnet6:                      bootstrapClusterCmdServiceSubnet6,
+				Cni:                                 bootstrapClusterCmdCni,
+				CiliumKubeProxyReplacement:          bootstrapClusterCmdCiliumKubeProxyReplacement,
+				NatGateway:                          bootstrapClusterCmdNatGateway,
+				NatGatewayType:                      bootstrapClusterCmdNatGatewayType,
+				ControlplanePublicNet:               bootstrapClusterCmdControlplanePublicNet,
+				ControlplaneImageSnapshot:           bootstrapClusterCmdControlplaneImageSnapshot,
+				ControlplaneLocations:               bootstrapClusterCmdControlplaneLocations,
+				BastionServerType:                   bootstrapClusterCmdBastionServerType,
+				BastionPort:                         bootstrapClusterCmdBastionPort,
+				BastionSubnet:                       bootstrapClusterCmdBastionSubnet,
+				Ingress:                             bootstrapClusterCmdIngress,
+				IngressPool:                         bootstrapClusterCmdIngressPool,
+				IngressLoadBalancerType:             bootstrapClusterCmdIngressLoadBalancerType,
+				IngressProxyProtocol:                bootstrapClusterCmdIngressProxyProtocol,
+				ControlplaneEndpoint:                bootstrapClusterCmdControlplaneEndpoint,
+				ControlplaneLoadBalancerType:        bootstrapClusterCmdControlplaneLoadBalancerType,
+				ControlplaneLoadBalancerAlgorithm:   bootstrapClusterCmdControlplaneLoadBalancerAlgorithm,
+				ControlplaneLoadBalancerHealthCheck: bootstrapClusterCmdControlplaneLoadBalancerHealthCheck,
+				TalosVersion:                        bootstrapClusterCmdTalosVersion,
+				KubernetesVersion:                   bootstrapClusterCmdKubernetesVersion,
 			})
-			return err
+			return printResult(bootstrapClusterCmdOutput, result, err)
 		},
 	}
 )
@@ -51,9 +108,38 @@ func init() {
 	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdLocation, "location", "nbg1", "")
 	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdNetworkZone, "network-zone", "eu-central", "")
 	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdNoFirewall, "no-firewall", false, "")
+	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdNoPreflight, "no-preflight", false, "")
 	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdNoTalosKubespan, "no-talos-kubespan", false, "")
 	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdNoHcloudCloudControllerManager, "no-hcloud-cloud-controller-manager", false, "")
 	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdNoHcloudCsiDriver, "no-hcloud-csi-driver", false, "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdExistingNetwork, "existing-network", "", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdNetworkIPRange, "network-ip-range", "", "")
+	bootstrapClusterCmd.Flags().StringSliceVar(&bootstrapClusterCmdNetworkNodeSubnets, "network-node-subnets", nil, "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdPodRouting, "pod-routing", "overlay", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdPodSubnet, "pod-subnet", "", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdServiceSubnet, "service-subnet", "", "")
+	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdDualStack, "dual-stack", false, "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdPodSubnet6, "pod-subnet6", "", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdServiceSubnet6, "service-subnet6", "", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdCni, "cni", "flannel", "")
+	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdCiliumKubeProxyReplacement, "cilium-kube-proxy-replacement", false, "")
+	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdNatGateway, "nat-gateway", false, "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdNatGatewayType, "nat-gateway-type", "cx22", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdControlplanePublicNet, "controlplane-public-net", "dual", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdControlplaneImageSnapshot, "controlplane-image-snapshot", "", "")
+	bootstrapClusterCmd.Flags().StringSliceVar(&bootstrapClusterCmdControlplaneLocations, "controlplane-locations", []string{}, "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdControlplaneEndpoint, "controlplane-endpoint", "load-balancer", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdControlplaneLoadBalancerType, "controlplane-load-balancer-type", "lb11", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdControlplaneLoadBalancerAlgorithm, "controlplane-load-balancer-algorithm", "round_robin", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdControlplaneLoadBalancerHealthCheck, "controlplane-load-balancer-health-check", "tcp", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdBastionServerType, "bastion-server-type", "cx22", "")
+	bootstrapClusterCmd.Flags().IntVar(&bootstrapClusterCmdBastionPort, "bastion-port", 51820, "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdBastionSubnet, "bastion-subnet", "192.168.254.0/24", "")
+	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdIngress, "ingress", false, "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdIngressPool, "ingress-pool", "", "")
+	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdIngressLoadBalancerType, "ingress-load-balancer-type", "lb11", "")
+	bootstrapClusterCmd.Flags().BoolVar(&bootstrapClusterCmdIngressProxyProtocol, "ingress-proxy-protocol", false, "")
 	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdTalosVersion, "talos-version", "", "")
 	bootstrapClusterCmd.Flags().StringVar(&bootstrapClusterCmdKubernetesVersion, "kubernetes-version", "", "")
+	bootstrapClusterCmd.Flags().StringVarP(&bootstrapClusterCmdOutput, "output", "o", "", "")
 }
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkCniCmdConfigFile string
+	checkCniCmdRepair     bool
+	checkCniCmdOutput     string
+	checkCniCmd           = &cobra.Command{
+		Use:   "check-cni",
+		Short: "Check and repair CNI configuration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			result, err := internal.CheckCni(&logger, dir, internal.CheckCniOpts{
+				ConfigFile: checkCniCmdConfigFile,
+				Repair:     checkCniCmdRepair,
+			})
+			return printResult(checkCniCmdOutput, result, err)
+		},
+	}
+)
+
+func init() {
+	checkCniCmd.Flags().StringVarP(&checkCniCmdConfigFile, "config", "c", defaultConfigFile, "")
+	checkCniCmd.Flags().BoolVar(&checkCniCmdRepair, "repair", false, "")
+	checkCniCmd.Flags().StringVarP(&checkCniCmdOutput, "output", "o", "", "")
+}
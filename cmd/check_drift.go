@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkDriftCmdConfigFile string
+	checkDriftCmdRepair     bool
+	checkDriftCmdOutput     string
+	checkDriftCmd           = &cobra.Command{
+		Use:   "check-drift",
+		Short: "Check and repair drift of hcloud resources",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			result, err := internal.CheckDrift(&logger, dir, internal.CheckDriftOpts{
+				ConfigFile: checkDriftCmdConfigFile,
+				Repair:     checkDriftCmdRepair,
+			})
+			return printResult(checkDriftCmdOutput, result, err)
+		},
+	}
+)
+
+func init() {
+	checkDriftCmd.Flags().StringVarP(&checkDriftCmdConfigFile, "config", "c", defaultConfigFile, "")
+	checkDriftCmd.Flags().BoolVar(&checkDriftCmdRepair, "repair", false, "")
+	checkDriftCmd.Flags().StringVarP(&checkDriftCmdOutput, "output", "o", "", "")
+}
@@ -10,19 +10,20 @@ var (
 	deleteNodeCmdConfigFile string
 	deleteNodeCmdKeepServer bool
 	deleteNodeCmdForce      bool
+	deleteNodeCmdOutput     string
 	deleteNodeCmd           = &cobra.Command{
 		Use:   "delete-node [node-name]",
 		Short: "Delete a node",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := utils.NewLogger(verbose)
-			err := internal.DeleteNode(&logger, dir, internal.DeleteNodeOpts{
+			result, err := internal.DeleteNode(&logger, dir, internal.DeleteNodeOpts{
 				ConfigFile: deleteNodeCmdConfigFile,
 				KeepServer: deleteNodeCmdKeepServer,
 				Force:      deleteNodeCmdForce,
 				NodeName:   args[0],
 			})
-			return err
+			return printResult(deleteNodeCmdOutput, result, err)
 		},
 	}
 )
@@ -31,4 +32,5 @@ func init() {
 	deleteNodeCmd.Flags().StringVarP(&deleteNodeCmdConfigFile, "config", "c", defaultConfigFile, "")
 	deleteNodeCmd.Flags().BoolVar(&deleteNodeCmdKeepServer, "keep-server", false, "")
 	deleteNodeCmd.Flags().BoolVar(&deleteNodeCmdForce, "force", false, "")
+	deleteNodeCmd.Flags().StringVarP(&deleteNodeCmdOutput, "output", "o", "", "")
 }
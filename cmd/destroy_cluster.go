@@ -9,17 +9,18 @@ import (
 var (
 	destroyClusterCmdConfigFile string
 	destroyClusterCmdForce      bool
+	destroyClusterCmdOutput     string
 	destroyClusterCmd           = &cobra.Command{
 		Use:   "destroy-cluster",
 		Short: "Destroy the cluster",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := utils.NewLogger(verbose)
-			err := internal.DestroyCluster(&logger, dir, internal.DestroyClusterOpts{
+			result, err := internal.DestroyCluster(&logger, dir, internal.DestroyClusterOpts{
 				ConfigFile: destroyClusterCmdConfigFile,
 				Force:      destroyClusterCmdForce,
 			})
-			return err
+			return printResult(destroyClusterCmdOutput, result, err)
 		},
 	}
 )
@@ -27,4 +28,5 @@ var (
 func init() {
 	destroyClusterCmd.Flags().StringVarP(&destroyClusterCmdConfigFile, "config", "c", defaultConfigFile, "")
 	destroyClusterCmd.Flags().BoolVar(&destroyClusterCmdForce, "force", false, "")
+	destroyClusterCmd.Flags().StringVarP(&destroyClusterCmdOutput, "output", "o", "", "")
 }
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorCmdConfigFile string
+	doctorCmd           = &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose cluster health",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			err := internal.Doctor(&logger, dir, internal.DoctorOpts{
+				ConfigFile: doctorCmdConfigFile,
+			})
+			return err
+		},
+	}
+)
+
+func init() {
+	doctorCmd.Flags().StringVarP(&doctorCmdConfigFile, "config", "c", defaultConfigFile, "")
+}
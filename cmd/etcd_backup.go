@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	etcdBackupCmdConfigFile string
+	etcdBackupCmdTargetDir  string
+	etcdBackupCmdS3Endpoint string
+	etcdBackupCmdS3Region   string
+	etcdBackupCmdS3Bucket   string
+	etcdBackupCmdS3Prefix   string
+	etcdBackupCmdS3Insecure bool
+	etcdBackupCmdKeep       int
+	etcdBackupCmdKeepWithin time.Duration
+	etcdBackupCmdOutput     string
+	etcdBackupCmd           = &cobra.Command{
+		Use:   "etcd-backup",
+		Short: "Backup etcd",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			result, err := internal.EtcdBackup(&logger, dir, internal.EtcdBackupOpts{
+				ConfigFile: etcdBackupCmdConfigFile,
+				TargetDir:  etcdBackupCmdTargetDir,
+				S3: internal.EtcdBackupS3Opts{
+					S3Opts: clients.S3Opts{
+						Endpoint:        etcdBackupCmdS3Endpoint,
+						Region:          etcdBackupCmdS3Region,
+						AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+						SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+						Insecure:        etcdBackupCmdS3Insecure,
+					},
+					Bucket: etcdBackupCmdS3Bucket,
+					Prefix: etcdBackupCmdS3Prefix,
+				},
+				Keep:       etcdBackupCmdKeep,
+				KeepWithin: etcdBackupCmdKeepWithin,
+			})
+			return printResult(etcdBackupCmdOutput, result, err)
+		},
+	}
+)
+
+func init() {
+	etcdBackupCmd.Flags().StringVarP(&etcdBackupCmdConfigFile, "config", "c", defaultConfigFile, "")
+	etcdBackupCmd.Flags().StringVar(&etcdBackupCmdTargetDir, "target-dir", "backups", "")
+	etcdBackupCmd.Flags().StringVar(&etcdBackupCmdS3Endpoint, "s3-endpoint", "", "")
+	etcdBackupCmd.Flags().StringVar(&etcdBackupCmdS3Region, "s3-region", "", "")
+	etcdBackupCmd.Flags().StringVar(&etcdBackupCmdS3Bucket, "s3-bucket", "", "")
+	etcdBackupCmd.Flags().StringVar(&etcdBackupCmdS3Prefix, "s3-prefix", "", "")
+	etcdBackupCmd.Flags().BoolVar(&etcdBackupCmdS3Insecure, "s3-insecure", false, "")
+	etcdBackupCmd.Flags().IntVar(&etcdBackupCmdKeep, "keep", 0, "")
+	etcdBackupCmd.Flags().DurationVar(&etcdBackupCmdKeepWithin, "keep-within", 0, "")
+	etcdBackupCmd.Flags().StringVarP(&etcdBackupCmdOutput, "output", "o", "", "")
+}
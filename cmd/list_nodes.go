@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listNodesCmdConfigFile string
+	listNodesCmdOutput     string
+	listNodesCmd           = &cobra.Command{
+		Use:   "list-nodes",
+		Short: "List nodes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			nodes, err := internal.ListNodes(&logger, dir, internal.ListNodesOpts{
+				ConfigFile: listNodesCmdConfigFile,
+			})
+			if err != nil {
+				return err
+			}
+			return printOutput(listNodesCmdOutput, nodes, func(w io.Writer) {
+				printNodesTable(w, nodes)
+			})
+		},
+	}
+)
+
+func init() {
+	listNodesCmd.Flags().StringVarP(&listNodesCmdConfigFile, "config", "c", defaultConfigFile, "")
+	listNodesCmd.Flags().StringVarP(&listNodesCmdOutput, "output", "o", "table", "")
+}
+
+func printNodesTable(w io.Writer, nodes []internal.NodeStatus) {
+	fmt.Fprintln(w, "NAME\tROLE\tPOOL\tSERVER TYPE\tLOCATION\tSERVER STATUS\tREADY\tPRIVATE IP\tPUBLIC IP\tKUBERNETES\tTALOS")
+	for _, node := range nodes {
+		ready := "-"
+		if node.Registered {
+			ready = fmt.Sprintf("%t", node.Ready)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			node.Name, node.Role, orDash(node.Pool), node.ServerType, node.Location, node.ServerStatus, ready,
+			orDash(node.PrivateIP), orDash(node.PublicIPv4), orDash(node.KubernetesVersion), orDash(node.TalosVersion))
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/airfocusio/hcloud-talos/internal"
+	"gopkg.in/yaml.v3"
+)
+
+// printOutput writes the result to stdout as JSON or YAML, or as a table
+// rendered by the given function, so that logs on stderr stay separate.
+func printOutput(format string, result interface{}, table func(w io.Writer)) error {
+	switch format {
+	case "", "table":
+		if table == nil {
+			return fmt.Errorf("output format %q is not supported, use json or yaml", format)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("output format %q is not supported, use table, json or yaml", format)
+	}
+}
+
+// printResult writes the result of a command that changes the cluster, if an
+// output format is given, and returns the error of the command. A failed
+// command still reports what it changed, e.g. to clean up created resources.
+func printResult(format string, result *internal.Result, err error) error {
+	if format == "" || result == nil {
+		return err
+	}
+	if printErr := printOutput(format, result, nil); printErr != nil && err == nil {
+		return printErr
+	}
+	return err
+}
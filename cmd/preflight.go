@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	preflightCmdConfigFile        string
+	preflightCmdServerType        string
+	preflightCmdControlplane      bool
+	preflightCmdPoolName          string
+	preflightCmdNodeCount         int
+	preflightCmdTalosVersion      string
+	preflightCmdKubernetesVersion string
+	preflightCmdLocation          string
+	preflightCmdNetworkZone       string
+	preflightCmdToken             string
+	preflightCmd                  = &cobra.Command{
+		Use:   "preflight",
+		Short: "Check prerequisites for adding nodes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			token := preflightCmdToken
+			if token == "" {
+				token = os.Getenv("HCLOUD_TOKEN")
+			}
+			err := internal.Preflight(&logger, dir, internal.PreflightOpts{
+				ConfigFile:        preflightCmdConfigFile,
+				ServerType:        preflightCmdServerType,
+				Controlplane:      preflightCmdControlplane,
+				PoolName:          preflightCmdPoolName,
+				NodeCount:         preflightCmdNodeCount,
+				TalosVersion:      preflightCmdTalosVersion,
+				KubernetesVersion: preflightCmdKubernetesVersion,
+				Location:          preflightCmdLocation,
+				NetworkZone:       preflightCmdNetworkZone,
+				Token:             token,
+			})
+			return err
+		},
+	}
+)
+
+func init() {
+	preflightCmd.Flags().StringVarP(&preflightCmdConfigFile, "config", "c", defaultConfigFile, "")
+	preflightCmd.Flags().StringVar(&preflightCmdServerType, "server-type", "cx22", "")
+	preflightCmd.Flags().BoolVar(&preflightCmdControlplane, "controlplane", false, "")
+	preflightCmd.Flags().StringVar(&preflightCmdPoolName, "pool-name", "", "")
+	preflightCmd.Flags().IntVar(&preflightCmdNodeCount, "node-count", 1, "")
+	preflightCmd.Flags().StringVar(&preflightCmdTalosVersion, "talos-version", "", "")
+	preflightCmd.Flags().StringVar(&preflightCmdKubernetesVersion, "kubernetes-version", "", "")
+	preflightCmd.Flags().StringVar(&preflightCmdLocation, "location", "", "")
+	preflightCmd.Flags().StringVar(&preflightCmdNetworkZone, "network-zone", "", "")
+	preflightCmd.Flags().StringVar(&preflightCmdToken, "token", "", "")
+}
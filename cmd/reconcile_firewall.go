@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reconcileFirewallCmdConfigFile string
+	reconcileFirewallCmdOutput     string
+	reconcileFirewallCmd           = &cobra.Command{
+		Use:   "reconcile-firewall",
+		Short: "Reconcile firewall rules",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			result, err := internal.ReconcileFirewall(&logger, dir, internal.ReconcileFirewallOpts{
+				ConfigFile: reconcileFirewallCmdConfigFile,
+			})
+			return printResult(reconcileFirewallCmdOutput, result, err)
+		},
+	}
+)
+
+func init() {
+	reconcileFirewallCmd.Flags().StringVarP(&reconcileFirewallCmdConfigFile, "config", "c", defaultConfigFile, "")
+	reconcileFirewallCmd.Flags().StringVarP(&reconcileFirewallCmdOutput, "output", "o", "", "")
+}
@@ -12,21 +12,24 @@ var (
 	reconcilePoolCmdNodeNamePrefix string
 	reconcilePoolCmdNodeCount      int
 	reconcilePoolCmdTalosVersion   string
+	reconcilePoolCmdNoPreflight    bool
+	reconcilePoolCmdOutput         string
 	reconcilePoolCmd               = &cobra.Command{
 		Use:   "reconcile-pool [pool-name]",
 		Short: "Reconcile pool",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			logger := utils.NewLogger(verbose)
-			err := internal.ReconcilePool(&logger, dir, internal.ReconcilePoolOpts{
+			result, err := internal.ReconcilePool(&logger, dir, internal.ReconcilePoolOpts{
 				ConfigFile:     reconcilePoolCmdConfigFile,
 				NodeNamePrefix: reconcilePoolCmdNodeNamePrefix,
 				NodeCount:      reconcilePoolCmdNodeCount,
 				ServerType:     reconcilePoolCmdServerType,
 				PoolName:       args[0],
 				TalosVersion:   reconcilePoolCmdTalosVersion,
+				NoPreflight:    reconcilePoolCmdNoPreflight,
 			})
-			return err
+			return printResult(reconcilePoolCmdOutput, result, err)
 		},
 	}
 )
@@ -37,4 +40,6 @@ func init() {
 	reconcilePoolCmd.Flags().StringVar(&reconcilePoolCmdNodeNamePrefix, "node-name-prefix", "worker", "")
 	reconcilePoolCmd.Flags().IntVar(&reconcilePoolCmdNodeCount, "node-count", 1, "")
 	reconcilePoolCmd.Flags().StringVar(&reconcilePoolCmdTalosVersion, "talos-version", "", "")
+	reconcilePoolCmd.Flags().BoolVar(&reconcilePoolCmdNoPreflight, "no-preflight", false, "")
+	reconcilePoolCmd.Flags().StringVarP(&reconcilePoolCmdOutput, "output", "o", "", "")
 }
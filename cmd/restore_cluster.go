@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	restoreClusterCmdConfigFile      string
+	restoreClusterCmdSkipHashCheck   bool
+	restoreClusterCmdServerType      string
+	restoreClusterCmdTalosVersion    string
+	restoreClusterCmdForce           bool
+	restoreClusterCmdOutput          string
+	restoreClusterCmdS3Endpoint      string
+	restoreClusterCmdS3Region        string
+	restoreClusterCmdS3Bucket        string
+	restoreClusterCmdS3Prefix        string
+	restoreClusterCmdS3Insecure      bool
+	restoreClusterCmdSkipUnreachable bool
+	restoreClusterCmd                = &cobra.Command{
+		Use:   "restore-cluster [snapshot-file] [node-name]",
+		Short: "Restore the cluster from an etcd snapshot",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			result, err := internal.RestoreCluster(&logger, dir, internal.RestoreClusterOpts{
+				ConfigFile:    restoreClusterCmdConfigFile,
+				SnapshotFile:  args[0],
+				SkipHashCheck: restoreClusterCmdSkipHashCheck,
+				NodeName:      args[1],
+				ServerType:    restoreClusterCmdServerType,
+				TalosVersion:  restoreClusterCmdTalosVersion,
+				Force:         restoreClusterCmdForce,
+				S3: internal.EtcdBackupS3Opts{
+					S3Opts: clients.S3Opts{
+						Endpoint:        restoreClusterCmdS3Endpoint,
+						Region:          restoreClusterCmdS3Region,
+						AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+						SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+						Insecure:        restoreClusterCmdS3Insecure,
+					},
+					Bucket: restoreClusterCmdS3Bucket,
+					Prefix: restoreClusterCmdS3Prefix,
+				},
+				SkipUnreachable: restoreClusterCmdSkipUnreachable,
+			})
+			return printResult(restoreClusterCmdOutput, result, err)
+		},
+	}
+)
+
+func init() {
+	restoreClusterCmd.Flags().StringVarP(&restoreClusterCmdConfigFile, "config", "c", defaultConfigFile, "")
+	restoreClusterCmd.Flags().BoolVar(&restoreClusterCmdSkipHashCheck, "skip-hash-check", false, "")
+	restoreClusterCmd.Flags().StringVar(&restoreClusterCmdServerType, "server-type", "cx22", "")
+	restoreClusterCmd.Flags().StringVar(&restoreClusterCmdTalosVersion, "talos-version", "", "")
+	restoreClusterCmd.Flags().BoolVar(&restoreClusterCmdForce, "force", false, "")
+	restoreClusterCmd.Flags().StringVarP(&restoreClusterCmdOutput, "output", "o", "", "")
+	restoreClusterCmd.Flags().StringVar(&restoreClusterCmdS3Endpoint, "s3-endpoint", "", "")
+	restoreClusterCmd.Flags().StringVar(&restoreClusterCmdS3Region, "s3-region", "", "")
+	restoreClusterCmd.Flags().StringVar(&restoreClusterCmdS3Bucket, "s3-bucket", "", "")
+	restoreClusterCmd.Flags().StringVar(&restoreClusterCmdS3Prefix, "s3-prefix", "", "")
+	restoreClusterCmd.Flags().BoolVar(&restoreClusterCmdS3Insecure, "s3-insecure", false, "")
+	restoreClusterCmd.Flags().BoolVar(&restoreClusterCmdSkipUnreachable, "skip-unreachable", false, "")
+}
@@ -19,11 +19,21 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&dir, "dir", "d", ".", "")
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(addNodeCmd)
+	rootCmd.AddCommand(applyConfigCmd)
 	rootCmd.AddCommand(applyManifestsCmd)
 	rootCmd.AddCommand(bootstrapClusterCmd)
+	rootCmd.AddCommand(checkCniCmd)
+	rootCmd.AddCommand(checkDriftCmd)
 	rootCmd.AddCommand(deleteNodeCmd)
 	rootCmd.AddCommand(destroyClusterCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(etcdBackupCmd)
+	rootCmd.AddCommand(listNodesCmd)
+	rootCmd.AddCommand(preflightCmd)
+	rootCmd.AddCommand(reconcileFirewallCmd)
 	rootCmd.AddCommand(reconcilePoolCmd)
+	rootCmd.AddCommand(restoreClusterCmd)
+	rootCmd.AddCommand(statusCmd)
 }
 
 func Execute() error {
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusCmdConfigFile string
+	statusCmdOutput     string
+	statusCmd           = &cobra.Command{
+		Use:   "status",
+		Short: "Show cluster status",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := utils.NewLogger(verbose)
+			status, err := internal.Status(&logger, dir, internal.StatusOpts{
+				ConfigFile: statusCmdConfigFile,
+			})
+			if err != nil {
+				return err
+			}
+			return printOutput(statusCmdOutput, status, func(w io.Writer) {
+				fmt.Fprintf(w, "Cluster:\t%s\n", status.ClusterName)
+				fmt.Fprintf(w, "Controlplane endpoint:\t%s (%s)\n", orDash(status.ControlplaneIP), status.ControlplaneEndpoint)
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, "POOL\tROLE\tNODES\tREADY")
+				for _, pool := range status.Pools {
+					fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", orDash(pool.Name), pool.Role, pool.Nodes, pool.Ready)
+				}
+				fmt.Fprintln(w)
+				printNodesTable(w, status.Nodes)
+			})
+		},
+	}
+)
+
+func init() {
+	statusCmd.Flags().StringVarP(&statusCmdConfigFile, "config", "c", defaultConfigFile, "")
+	statusCmd.Flags().StringVarP(&statusCmdOutput, "output", "o", "table", "")
+}
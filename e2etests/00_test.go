@@ -75,7 +75,7 @@ func setup() {
 func cleanup() {
 	fmt.Printf("cleanup\n")
 
-	err := internal.DestroyCluster(&logger, clusterDir, internal.DestroyClusterOpts{
+	_, err := internal.DestroyCluster(&logger, clusterDir, internal.DestroyClusterOpts{
 		ConfigFile: configFile,
 		Force:      true,
 	})
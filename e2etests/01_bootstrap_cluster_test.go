@@ -8,7 +8,7 @@ import (
 )
 
 func TestBootstrapCluster(t *testing.T) {
-	err := internal.BootstrapCluster(&logger, clusterDir, internal.BootstrapClusterOpts{
+	_, err := internal.BootstrapCluster(&logger, clusterDir, internal.BootstrapClusterOpts{
 		ConfigFile:        configFile,
 		ClusterName:       clusterName,
 		ServerType:        "cx22",
@@ -16,6 +16,8 @@ func TestBootstrapCluster(t *testing.T) {
 		Location:          "nbg1",
 		NetworkZone:       "eu-central",
 		Token:             hcloudToken,
+		PodRouting:        "overlay",
+		Cni:               "flannel",
 		TalosVersion:      talosVersion,
 		KubernetesVersion: kubernetesVersion,
 	})
@@ -10,7 +10,7 @@ import (
 func TestDeleteNode(t *testing.T) {
 	t.Skip()
 
-	err := internal.DeleteNode(&logger, clusterDir, internal.DeleteNodeOpts{
+	_, err := internal.DeleteNode(&logger, clusterDir, internal.DeleteNodeOpts{
 		ConfigFile: configFile,
 		NodeName:   "worker",
 		Force:      true,
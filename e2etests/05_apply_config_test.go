@@ -0,0 +1,17 @@
+package e2etests
+
+import (
+	"testing"
+
+	"github.com/airfocusio/hcloud-talos/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyConfig(t *testing.T) {
+	_, err := internal.ApplyConfig(&logger, clusterDir, internal.ApplyConfigOpts{
+		ConfigFile: configFile,
+		Mode:       "auto",
+		DryRun:     true,
+	})
+	assert.NoError(t, err)
+}
@@ -1,7 +1,10 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/airfocusio/hcloud-talos/internal/clients"
 	"github.com/airfocusio/hcloud-talos/internal/cluster"
@@ -16,11 +19,22 @@ type AddNodeOpts struct {
 	NodeName     string
 	PoolName     string
 	TalosVersion string
+	NoPreflight  bool
 }
 
-func AddNode(logger *utils.Logger, dir string, opts AddNodeOpts) (*hcloud.Server, error) {
+func AddNode(logger *utils.Logger, dir string, opts AddNodeOpts) (result *Result, err error) {
+	start := time.Now()
 	cl := &cluster.Cluster{Dir: dir}
-	err := cl.Load(opts.ConfigFile, logger)
+	var server *hcloud.Server
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+			if server != nil {
+				result.addNode(server, start)
+			}
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -34,35 +48,42 @@ func AddNode(logger *utils.Logger, dir string, opts AddNodeOpts) (*hcloud.Server
 	if opts.TalosVersion == "" {
 		return nil, fmt.Errorf("talos version must not be empty")
 	}
-
-	network, err := clients.HcloudEnsureNetwork(cl, nodeNetworkTemplate(cl), false)
-	if err != nil {
+	pool := cl.Config.FindPool(opts.PoolName)
+	if opts.Controlplane {
+		pool = cl.Config.Controlplane
+	}
+	if err := validatePool(cl, pool); err != nil {
 		return nil, err
 	}
 
-	var placementGroup *hcloud.PlacementGroup
-	if opts.Controlplane {
-		controlplanePlacementGroup, err := clients.HcloudEnsurePlacementGroup(cl, controlplanePlacementGroupTemplate(cl), false)
+	if !opts.NoPreflight {
+		err := preflight(cl, preflightChecks{
+			ServerType:   opts.ServerType,
+			Pool:         pool,
+			NodeCount:    1,
+			TalosVersion: opts.TalosVersion,
+		})
 		if err != nil {
 			return nil, err
 		}
-		placementGroup = controlplanePlacementGroup
 	}
 
-	var nodeTemplate clients.HcloudServerCreateFromImageOpts
-	if opts.Controlplane {
-		nodeTemplate, err = controlplaneNodeTemplate(cl, opts.ServerType, opts.NodeName, opts.TalosVersion)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		nodeTemplate, err = workerNodeTemplate(cl, opts.ServerType, opts.PoolName, opts.NodeName, opts.TalosVersion)
-		if err != nil {
-			return nil, err
-		}
+	network, err := ensureNodeNetwork(cl, false)
+	if err != nil {
+		return nil, err
 	}
 
-	server, err := clients.HcloudCreateServerFromImage(cl, network, placementGroup, nodeTemplate)
+	server, err = createNodeServer(cl, network, opts.ServerType, opts.Controlplane, opts.PoolName, opts.NodeName, opts.TalosVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = reconcileFirewalls(cl, network, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ensureControlplaneFloatingIPAssigned(cl, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -72,5 +93,116 @@ func AddNode(logger *utils.Logger, dir string, opts AddNodeOpts) (*hcloud.Server
 		return nil, err
 	}
 
-	return server, nil
+	result = newResult(cl, start)
+	result.addNode(server, start)
+	return result, nil
+}
+
+type nodeServerCandidate struct {
+	serverType string
+	location   string
+}
+
+// nodeServerCandidates returns the server types and locations a new node can
+// be created with, in the order they are tried: all server types in the
+// preferred location first, then in the other locations of the pool. The
+// given server type comes before the fallback server types of the pool.
+func nodeServerCandidates(cl *cluster.Cluster, serverType string, pool cluster.ConfigPool, location string) []nodeServerCandidate {
+	serverTypes := []string{serverType}
+	for _, fallbackServerType := range pool.ServerTypes {
+		if !slices.Contains(serverTypes, fallbackServerType) {
+			serverTypes = append(serverTypes, fallbackServerType)
+		}
+	}
+	locations := []string{location}
+	for _, fallbackLocation := range cl.Config.PoolLocations(pool) {
+		if !slices.Contains(locations, fallbackLocation) {
+			locations = append(locations, fallbackLocation)
+		}
+	}
+	candidates := []nodeServerCandidate{}
+	for _, location := range locations {
+		for _, serverType := range serverTypes {
+			candidates = append(candidates, nodeServerCandidate{serverType: serverType, location: location})
+		}
+	}
+	return candidates
+}
+
+// createNodeServer creates the server of a new node. When hcloud has no
+// capacity for a server type in a location, the next candidate is tried.
+func createNodeServer(cl *cluster.Cluster, network *hcloud.Network, serverType string, controlplane bool, poolName string, name string, talosVersion string) (*hcloud.Server, error) {
+	role := "worker"
+	pool := cl.Config.FindPool(poolName)
+	if controlplane {
+		role = "controlplane"
+		pool = cl.Config.Controlplane
+	}
+	location, err := nodeLocation(cl, role, pool)
+	if err != nil {
+		return nil, err
+	}
+	candidates := nodeServerCandidates(cl, serverType, pool, location)
+	for i, candidate := range candidates {
+		var nodeTemplate clients.HcloudServerCreateFromImageOpts
+		var placementGroup *hcloud.PlacementGroup
+		created := len(cl.Created)
+		if controlplane {
+			nodeTemplate, err = controlplaneNodeTemplate(cl, candidate.serverType, candidate.location, name, talosVersion)
+			if err != nil {
+				return nil, err
+			}
+			placementGroup, _, err = clients.HcloudEnsurePlacementGroup(cl, controlplanePlacementGroupTemplate(cl, candidate.location), true, false)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			nodeTemplate, err = workerNodeTemplate(cl, candidate.serverType, candidate.location, poolName, name, talosVersion)
+			if err != nil {
+				return nil, err
+			}
+			placementGroup, err = ensureWorkerPlacementGroup(cl, poolName, candidate.location)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		server, err := clients.HcloudCreateServerFromImage(cl, network, placementGroup, nodeTemplate)
+		if err != nil {
+			if err := deleteCreatedPlacementGroup(cl, placementGroup, cl.Created[created:]); err != nil {
+				cl.Logger.Warn.Printf("Placement group %q could not be deleted: %v\n", placementGroup.Name, err)
+			}
+		}
+		var capacityErr *clients.HcloudCapacityError
+		if errors.As(err, &capacityErr) && i < len(candidates)-1 {
+			next := candidates[i+1]
+			cl.Logger.Warn.Printf("%v, falling back to server type %s in location %s\n", err, next.serverType, next.location)
+			continue
+		}
+		return server, err
+	}
+	return nil, fmt.Errorf("no server type and location to create the node with")
+}
+
+// deleteCreatedPlacementGroup deletes the placement group if it has just been
+// created for a server that could not be created, so that falling back to
+// another location does not leave an empty placement group behind.
+func deleteCreatedPlacementGroup(cl *cluster.Cluster, placementGroup *hcloud.PlacementGroup, created []cluster.Resource) error {
+	if !slices.Contains(created, cluster.Resource{Type: "placement-group", Name: placementGroup.Name, ID: placementGroup.ID}) {
+		return nil
+	}
+	placementGroup, _, err := cl.Client.PlacementGroup.GetByID(*cl.Ctx, placementGroup.ID)
+	if err != nil {
+		return err
+	}
+	if placementGroup == nil || len(placementGroup.Servers) > 0 {
+		return nil
+	}
+	cl.Logger.Info.Printf("Deleting unused placement group %q\n", placementGroup.Name)
+	_, err = cl.Client.PlacementGroup.Delete(*cl.Ctx, placementGroup)
+	if err != nil {
+		return err
+	}
+	cl.RecordDeleted("placement-group", placementGroup.Name, placementGroup.ID)
+	return nil
 }
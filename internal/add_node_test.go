@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+)
+
+func TestNodeServerCandidates(t *testing.T) {
+	cl := &cluster.Cluster{Config: cluster.Config{Hcloud: cluster.ConfigHcloud{Location: "nbg1"}}}
+	tests := []struct {
+		name       string
+		serverType string
+		pool       cluster.ConfigPool
+		location   string
+		expected   []nodeServerCandidate
+	}{
+		{
+			name:       "without fallbacks",
+			serverType: "cx22",
+			location:   "nbg1",
+			expected:   []nodeServerCandidate{{serverType: "cx22", location: "nbg1"}},
+		},
+		{
+			name:       "server types before locations",
+			serverType: "cx22",
+			pool:       cluster.ConfigPool{ServerTypes: []string{"cx32", "cpx21"}, Locations: []string{"nbg1", "fsn1"}},
+			location:   "fsn1",
+			expected: []nodeServerCandidate{
+				{serverType: "cx22", location: "fsn1"},
+				{serverType: "cx32", location: "fsn1"},
+				{serverType: "cpx21", location: "fsn1"},
+				{serverType: "cx22", location: "nbg1"},
+				{serverType: "cx32", location: "nbg1"},
+				{serverType: "cpx21", location: "nbg1"},
+			},
+		},
+		{
+			name:       "de-duplicated",
+			serverType: "cx32",
+			pool:       cluster.ConfigPool{ServerTypes: []string{"cx22", "cx32", "cx22"}, Locations: []string{"nbg1", "nbg1"}},
+			location:   "nbg1",
+			expected: []nodeServerCandidate{
+				{serverType: "cx32", location: "nbg1"},
+				{serverType: "cx22", location: "nbg1"},
+			},
+		},
+		{
+			name:       "cluster location as fallback",
+			serverType: "cx22",
+			location:   "hel1",
+			expected: []nodeServerCandidate{
+				{serverType: "cx22", location: "hel1"},
+				{serverType: "cx22", location: "nbg1"},
+			},
+		},
+	}
+	for _, test := range tests {
+		actual := nodeServerCandidates(cl, test.serverType, test.pool, test.location)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: nodeServerCandidates = %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+type ApplyConfigOpts struct {
+	ConfigFile string
+	NodeNames  []string
+	Mode       string
+	DryRun     bool
+}
+
+func ApplyConfig(logger *utils.Logger, dir string, opts ApplyConfigOpts) (result *Result, err error) {
+	start := time.Now()
+	cl := &cluster.Cluster{Dir: dir}
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Applying config to nodes of cluster %s (mode %s)\n", cl.Config.ClusterName, opts.Mode)
+	switch opts.Mode {
+	case "auto", "no-reboot", "reboot", "staged":
+	default:
+		return nil, fmt.Errorf("mode must be one of auto, no-reboot, reboot or staged")
+	}
+
+	servers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.NodeNames) > 0 {
+		serverNames := map[string]bool{}
+		for _, name := range opts.NodeNames {
+			serverNames[nodeName(cl, name)] = true
+		}
+		filteredServers := []*hcloud.Server{}
+		for _, server := range servers {
+			if serverNames[server.Name] {
+				filteredServers = append(filteredServers, server)
+				delete(serverNames, server.Name)
+			}
+		}
+		for serverName := range serverNames {
+			return nil, fmt.Errorf("server %q could not be found", serverName)
+		}
+		servers = filteredServers
+	}
+
+	err = applyConfigToServers(cl, servers, opts.Mode, opts.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	result = newResult(cl, start)
+	for _, server := range servers {
+		result.addNode(server, time.Time{})
+	}
+	return result, nil
+}
+
+func applyConfigToServers(cl *cluster.Cluster, servers []*hcloud.Server, mode string, dryRun bool) error {
+	logger := cl.Logger
+
+	// controlplanes go first, so that workers are never ahead of them
+	sort.SliceStable(servers, func(i, j int) bool {
+		iControlplane := servers[i].Labels[roleLabel] == "controlplane"
+		jControlplane := servers[j].Labels[roleLabel] == "controlplane"
+		if iControlplane != jControlplane {
+			return iControlplane
+		}
+		return servers[i].Name < servers[j].Name
+	})
+
+	var healthNodeIP net.IP
+	for _, server := range servers {
+		if server.Labels[roleLabel] == "controlplane" && len(server.PrivateNet) > 0 {
+			healthNodeIP = server.PrivateNet[0].IP
+			break
+		}
+	}
+
+	for _, server := range servers {
+		if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
+			return fmt.Errorf("server %q private IP could not be determined", server.Name)
+		}
+		serverIP := server.PrivateNet[0].IP
+		role := server.Labels[roleLabel]
+		pool := cl.Config.FindPool(server.Labels[poolLabel])
+		if role == "controlplane" {
+			pool = cl.Config.Controlplane
+		}
+
+		location := ""
+		if server.Datacenter != nil && server.Datacenter.Location != nil {
+			location = server.Datacenter.Location.Name
+		}
+		nodeConfig, err := nodeConfigTemplate(cl, server.Name, role, pool, location)
+		if err != nil {
+			return err
+		}
+		bootID := ""
+		if (mode == "reboot" || mode == "auto") && !dryRun {
+			bootID, err = clients.KubernetesNodeBootID(cl, server.Name)
+			if err != nil {
+				return err
+			}
+		}
+		applied, rebooting, err := applyNodeConfig(cl, server.Name, serverIP, nodeConfig, mode, dryRun)
+		if err != nil {
+			return err
+		}
+		if !applied || mode == "staged" {
+			continue
+		}
+
+		// the node is still ready right after the config has been applied,
+		// until the reboot has actually started
+		if rebooting {
+			logger.Debug.Printf("Waiting for node %s to reboot\n", server.Name)
+			err = clients.KubernetesWaitNodeRebooted(cl, server.Name, bootID)
+			if err != nil {
+				return err
+			}
+		}
+
+		logger.Debug.Printf("Waiting for node %s to become ready\n", server.Name)
+		err = clients.KubernetesWaitNodeReady(cl, server.Name)
+		if err != nil {
+			return err
+		}
+		if healthNodeIP != nil {
+			logger.Debug.Printf("Waiting for cluster to become healthy\n")
+			err = utils.RetrySlow(logger, func() error {
+				_, err := TalosHealth(cl, healthNodeIP)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyNodeConfig shows the diff of the node config and applies it unless
+// there are no changes or it is a dry run. The temporary config file is
+// removed before returning, so that it does not outlive the node. It also
+// reports whether the node reboots to apply the config.
+func applyNodeConfig(cl *cluster.Cluster, serverName string, serverIP net.IP, nodeConfig string, mode string, dryRun bool) (bool, bool, error) {
+	nodeConfigFile, err := os.CreateTemp("", "hcloud-talos-*.yaml")
+	if err != nil {
+		return false, false, err
+	}
+	_, err = nodeConfigFile.WriteString(nodeConfig)
+	nodeConfigFile.Close()
+	if err != nil {
+		os.Remove(nodeConfigFile.Name())
+		return false, false, err
+	}
+
+	applied, rebooting, err := applyNodeConfigFile(cl, serverName, serverIP, nodeConfigFile.Name(), mode, dryRun)
+	os.Remove(nodeConfigFile.Name())
+	return applied, rebooting, err
+}
+
+func applyNodeConfigFile(cl *cluster.Cluster, serverName string, serverIP net.IP, nodeConfigFile string, mode string, dryRun bool) (bool, bool, error) {
+	diff, err := TalosApplyConfig(cl, serverIP, nodeConfigFile, mode, true)
+	if err != nil {
+		return false, false, err
+	}
+	if strings.Contains(diff, "No changes.") {
+		cl.Logger.Info.Printf("Node %s is up to date\n", serverName)
+		return false, false, nil
+	}
+	cl.Logger.Info.Printf("Node %s config diff:\n%s\n", serverName, diff)
+	if dryRun {
+		return false, false, nil
+	}
+
+	cl.Logger.Info.Printf("Applying config to node %s\n", serverName)
+	output, err := TalosApplyConfig(cl, serverIP, nodeConfigFile, mode, false)
+	if err != nil {
+		return false, false, err
+	}
+	return true, applyConfigRebooting(mode, output), nil
+}
+
+// applyConfigRebooting tells whether applying the config reboots the node,
+// which in auto mode is only known from the talosctl output.
+func applyConfigRebooting(mode string, output string) bool {
+	switch mode {
+	case "reboot":
+		return true
+	case "auto":
+		return strings.Contains(output, "with a reboot")
+	default:
+		return false
+	}
+}
@@ -0,0 +1,23 @@
+package internal
+
+import "testing"
+
+func TestApplyConfigRebooting(t *testing.T) {
+	tests := []struct {
+		mode     string
+		output   string
+		expected bool
+	}{
+		{mode: "reboot", output: "Applied configuration with a reboot", expected: true},
+		{mode: "auto", output: "Applied configuration with a reboot", expected: true},
+		{mode: "auto", output: "Applied configuration without a reboot", expected: false},
+		{mode: "auto", output: "", expected: false},
+		{mode: "no-reboot", output: "Applied configuration without a reboot", expected: false},
+		{mode: "staged", output: "Staged configuration to be applied after the next reboot", expected: false},
+	}
+	for _, test := range tests {
+		if actual := applyConfigRebooting(test.mode, test.output); actual != test.expected {
+			t.Errorf("applyConfigRebooting(%q, %q) = %v, expected %v", test.mode, test.output, actual, test.expected)
+		}
+	}
+}
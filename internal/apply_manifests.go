@@ -2,10 +2,15 @@ package internal
 
 import (
 	_ "embed"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/airfocusio/hcloud-talos/internal/clients"
 	"github.com/airfocusio/hcloud-talos/internal/cluster"
 	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	v1 "k8s.io/api/core/v1"
 )
 
 var (
@@ -15,6 +20,10 @@ var (
 	hcloudCloudControllerManagerManifestTmpl string
 	//go:embed apply_manifests_hcloud_csi_driver.yaml
 	hcloudCsiDriverManifestTmpl string
+	//go:embed apply_manifests_cilium.yaml
+	ciliumManifestTmpl string
+	//go:embed apply_manifests_ingress_nginx.yaml
+	ingressNginxManifestTmpl string
 )
 
 type ApplyManifestsOpts struct {
@@ -23,16 +32,26 @@ type ApplyManifestsOpts struct {
 	NoHcloudCsiDriver              bool
 }
 
-func ApplyManifests(logger *utils.Logger, dir string, opts ApplyManifestsOpts) error {
+func ApplyManifests(logger *utils.Logger, dir string, opts ApplyManifestsOpts) (result *Result, err error) {
+	start := time.Now()
 	cl := &cluster.Cluster{Dir: dir}
-	err := cl.Load(opts.ConfigFile, logger)
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := cl.Config.Ingress.Validate(cl.Config.Pools); err != nil {
+		return nil, err
 	}
 
-	network, err := clients.HcloudEnsureNetwork(cl, nodeNetworkTemplate(cl), false)
+	network, err := ensureNodeNetwork(cl, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	hcloudSecretManifest, err := utils.RenderTemplate(hcloudSecretManifestTmpl, map[string]interface{}{
@@ -40,37 +59,144 @@ func ApplyManifests(logger *utils.Logger, dir string, opts ApplyManifestsOpts) e
 		"Network": network.Name,
 	})
 
-	hcloudCloudControllerManagerManifest, err := utils.RenderTemplate(hcloudCloudControllerManagerManifestTmpl, map[string]interface{}{})
+	ciliumManifest, err := utils.RenderTemplate(ciliumManifestTmpl, map[string]interface{}{
+		"KubeProxyReplacement": cl.Config.Cni.KubeProxyReplacement,
+		"NativeRouting":        cl.Config.Network.IsNativePodRouting(),
+		"NativeRoutingCIDR":    network.IPRange.String(),
+		"DualStack":            cl.Config.Network.DualStack,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hcloudCloudControllerManagerManifest, err := utils.RenderTemplate(hcloudCloudControllerManagerManifestTmpl, map[string]interface{}{
+		"NetworkRoutes": cl.Config.Network.IsNativePodRouting(),
+		"PodSubnet":     cl.Config.Network.PodSubnet,
+		"DualStack":     cl.Config.Network.DualStack,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	hcloudCsiDriverManifest, err := utils.RenderTemplate(hcloudCsiDriverManifestTmpl, map[string]interface{}{})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	ingressNginxManifest := ""
+	if cl.Config.Ingress.Enabled {
+		ingressLoadBalancer, _, err := clients.HcloudEnsureLoadBalancer(cl, network, ingressLoadBalancerTemplate(cl, network), true, true)
+		if err != nil {
+			return nil, err
+		}
+		nodeSelector := map[string]string{roleLabel: "worker"}
+		tolerations := []cluster.ConfigTaint{}
+		if cl.Config.Ingress.Pool != "" {
+			nodeSelector[poolLabel] = cl.Config.Ingress.Pool
+			tolerations = cl.Config.FindPool(cl.Config.Ingress.Pool).Taints
+		}
+		nodes, err := clients.KubernetesListNodes(cl)
+		if err != nil {
+			return nil, err
+		}
+		matchingNodes, unlabeledNodes := ingressNodes(nodes, nodeSelector)
+		if matchingNodes == 0 && len(unlabeledNodes) > 0 {
+			return nil, fmt.Errorf("ingress-nginx could not be scheduled, as nodes %s lack the %s label, run apply-config first", strings.Join(unlabeledNodes, ", "), roleLabel)
+		}
+		if matchingNodes == 0 {
+			cl.Logger.Warn.Printf("No nodes for ingress-nginx yet, it is scheduled once workers join\n")
+		}
+		ingressNginxManifest, err = utils.RenderTemplate(ingressNginxManifestTmpl, map[string]interface{}{
+			"ProxyProtocol":        cl.Config.Ingress.ProxyProtocol,
+			"HttpNodePort":         ingressHttpNodePort,
+			"HttpsNodePort":        ingressHttpsNodePort,
+			"PublishStatusAddress": ingressLoadBalancer.PublicNet.IPv4.IP.String(),
+			"NodeSelector":         nodeSelector,
+			"Tolerations":          tolerations,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = deleteIngressLoadBalancer(cl, network)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	manifestsConcatenated := [][]byte{}
 	manifestsConcatenated = append(manifestsConcatenated, []byte(hcloudSecretManifest))
+	if cl.Config.Cni.IsCilium() {
+		manifestsConcatenated = append(manifestsConcatenated, []byte(ciliumManifest))
+	}
 	if !opts.NoHcloudCloudControllerManager {
 		manifestsConcatenated = append(manifestsConcatenated, []byte(hcloudCloudControllerManagerManifest))
 	}
 	if !opts.NoHcloudCsiDriver {
 		manifestsConcatenated = append(manifestsConcatenated, []byte(hcloudCsiDriverManifest))
 	}
+	if cl.Config.Ingress.Enabled {
+		manifestsConcatenated = append(manifestsConcatenated, []byte(ingressNginxManifest))
+	}
 
 	manifests, err := utils.YamlSplitMany(manifestsConcatenated...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, manifest := range manifests {
 		err = utils.Retry(cl.Logger, func() error {
 			return clients.KubernetesCreateFromManifest(cl, string(manifest))
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	return newResult(cl, start), nil
+}
+
+// ingressNodes counts the nodes ingress-nginx can be scheduled on and returns
+// the names of nodes created before they were labeled with their role, which
+// apply-config labels.
+func ingressNodes(nodes []v1.Node, nodeSelector map[string]string) (int, []string) {
+	matching := 0
+	unlabeled := []string{}
+	for _, node := range nodes {
+		if _, ok := node.Labels[roleLabel]; !ok {
+			unlabeled = append(unlabeled, node.Name)
+			continue
+		}
+		matches := true
+		for key, value := range nodeSelector {
+			if node.Labels[key] != value {
+				matches = false
+			}
+		}
+		if matches {
+			matching++
+		}
+	}
+	return matching, unlabeled
+}
+
+// deleteIngressLoadBalancer deletes the ingress load balancer once ingress
+// has been disabled. ingress-nginx itself is left in the cluster.
+func deleteIngressLoadBalancer(cl *cluster.Cluster, network *hcloud.Network) error {
+	loadBalancer, _, err := cl.Client.LoadBalancer.Get(*cl.Ctx, ingressLoadBalancerTemplate(cl, network).Name)
+	if err != nil {
+		return err
+	}
+	if loadBalancer == nil {
+		return nil
+	}
+	cl.Logger.Info.Printf("Deleting ingress load balancer %q, as ingress is disabled\n", loadBalancer.Name)
+	err = utils.Retry(cl.Logger, func() error {
+		_, err := cl.Client.LoadBalancer.Delete(*cl.Ctx, loadBalancer)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	cl.RecordDeleted("load-balancer", loadBalancer.Name, loadBalancer.ID)
 	return nil
 }
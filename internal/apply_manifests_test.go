@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIngressNodes(t *testing.T) {
+	node := func(name string, labels map[string]string) v1.Node {
+		return v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	tests := []struct {
+		name              string
+		nodes             []v1.Node
+		nodeSelector      map[string]string
+		expectedMatching  int
+		expectedUnlabeled []string
+	}{
+		{
+			name:              "no nodes",
+			nodeSelector:      map[string]string{roleLabel: "worker"},
+			expectedUnlabeled: []string{},
+		},
+		{
+			name: "workers",
+			nodes: []v1.Node{
+				node("controlplane-1", map[string]string{roleLabel: "controlplane"}),
+				node("worker-1", map[string]string{roleLabel: "worker"}),
+				node("worker-2", map[string]string{roleLabel: "worker", poolLabel: "ingress"}),
+			},
+			nodeSelector:      map[string]string{roleLabel: "worker"},
+			expectedMatching:  2,
+			expectedUnlabeled: []string{},
+		},
+		{
+			name: "pool",
+			nodes: []v1.Node{
+				node("worker-1", map[string]string{roleLabel: "worker"}),
+				node("worker-2", map[string]string{roleLabel: "worker", poolLabel: "ingress"}),
+			},
+			nodeSelector:      map[string]string{roleLabel: "worker", poolLabel: "ingress"},
+			expectedMatching:  1,
+			expectedUnlabeled: []string{},
+		},
+		{
+			name: "nodes without role label",
+			nodes: []v1.Node{
+				node("controlplane-1", map[string]string{roleLabel: "controlplane"}),
+				node("worker-1", map[string]string{}),
+				node("worker-2", nil),
+			},
+			nodeSelector:      map[string]string{roleLabel: "worker"},
+			expectedMatching:  0,
+			expectedUnlabeled: []string{"worker-1", "worker-2"},
+		},
+	}
+	for _, test := range tests {
+		matching, unlabeled := ingressNodes(test.nodes, test.nodeSelector)
+		if matching != test.expectedMatching || !reflect.DeepEqual(unlabeled, test.expectedUnlabeled) {
+			t.Errorf("%s: ingressNodes = %d, %v, expected %d, %v", test.name, matching, unlabeled, test.expectedMatching, test.expectedUnlabeled)
+		}
+	}
+}
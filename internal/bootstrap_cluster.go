@@ -2,107 +2,312 @@ package internal
 
 import (
 	"fmt"
+	"net"
+	"path"
+	"path/filepath"
+	"time"
 
 	"github.com/airfocusio/hcloud-talos/internal/clients"
 	"github.com/airfocusio/hcloud-talos/internal/cluster"
 	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
 )
 
 type BootstrapClusterOpts struct {
-	ConfigFile                     string
-	ClusterName                    string
-	NodeName                       string
-	ServerType                     string
-	Location                       string
-	NetworkZone                    string
-	Token                          string
-	NoFirewall                     bool
-	NoTalosKubespan                bool
-	NoHcloudCloudControllerManager bool
-	NoHcloudCsiDriver              bool
-	TalosVersion                   string
-	KubernetesVersion              string
+	ConfigFile                          string
+	ClusterName                         string
+	NodeName                            string
+	ServerType                          string
+	Location                            string
+	NetworkZone                         string
+	Token                               string
+	NoFirewall                          bool
+	NoPreflight                         bool
+	NoTalosKubespan                     bool
+	NoHcloudCloudControllerManager      bool
+	NoHcloudCsiDriver                   bool
+	ExistingNetwork                     string
+	NetworkIPRange                      string
+	NetworkNodeSubnets                  []string
+	PodRouting                          string
+	PodSubnet                           string
+	ServiceSubnet                       string
+	DualStack                           bool
+	PodSubnet6                          string
+	ServiceSubnet6                      string
+	Cni                                 string
+	CiliumKubeProxyReplacement          bool
+	NatGateway                          bool
+	NatGatewayType                      string
+	ControlplanePublicNet               string
+	ControlplaneImageSnapshot           string
+	ControlplaneLocations               []string
+	ControlplaneEndpoint                string
+	ControlplaneLoadBalancerType        string
+	ControlplaneLoadBalancerAlgorithm   string
+	ControlplaneLoadBalancerHealthCheck string
+	BastionServerType                   string
+	BastionPort                         int
+	BastionSubnet                       string
+	Ingress                             bool
+	IngressPool                         string
+	IngressLoadBalancerType             string
+	IngressProxyProtocol                bool
+	TalosVersion                        string
+	KubernetesVersion                   string
 }
 
-func BootstrapCluster(logger *utils.Logger, dir string, opts BootstrapClusterOpts) error {
+func BootstrapCluster(logger *utils.Logger, dir string, opts BootstrapClusterOpts) (result *Result, err error) {
+	start := time.Now()
 	cl := &cluster.Cluster{Dir: dir}
-	err := cl.Create(logger, opts.ClusterName, opts.Location, opts.NetworkZone, opts.Token)
+	var applyManifestsResult *Result
+	var controlplaneServer *hcloud.Server
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+			if controlplaneServer != nil {
+				result.addNode(controlplaneServer, time.Time{})
+			}
+			if applyManifestsResult != nil {
+				result.merge(applyManifestsResult)
+			}
+		}
+	}()
+	err = cl.Create(logger, opts.ClusterName, opts.Location, opts.NetworkZone, opts.Token)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = cl.Save(opts.ConfigFile)
-	if err != nil {
-		return err
+	cl.Config.Network.ExistingNetwork = opts.ExistingNetwork
+	cl.Config.Network.IPRange = opts.NetworkIPRange
+	var existingNetwork *hcloud.Network
+	if opts.ExistingNetwork != "" {
+		existingNetwork, _, err = cl.Client.Network.Get(*cl.Ctx, opts.ExistingNetwork)
+		if err != nil {
+			return nil, err
+		}
+		if existingNetwork == nil {
+			return nil, fmt.Errorf("existing network %q could not be found", opts.ExistingNetwork)
+		}
+		cl.Config.Network.IPRange = existingNetwork.IPRange.String()
+	}
+	cl.Config.Network.NodeSubnets = opts.NetworkNodeSubnets
+	cl.Config.Network.PodRouting = opts.PodRouting
+	cl.Config.Network.PodSubnet = opts.PodSubnet
+	cl.Config.Network.ServiceSubnet = opts.ServiceSubnet
+	cl.Config.Network.DualStack = opts.DualStack
+	if opts.DualStack {
+		cl.Config.Network.PodSubnet6 = opts.PodSubnet6
+		cl.Config.Network.ServiceSubnet6 = opts.ServiceSubnet6
+	}
+	cl.Config.Network.SetDefaults()
+	cl.Config.Cni.Name = opts.Cni
+	cl.Config.Cni.KubeProxyReplacement = opts.CiliumKubeProxyReplacement
+	cl.Config.Network.NatGateway = opts.NatGateway
+	cl.Config.Network.NatGatewayType = opts.NatGatewayType
+	cl.Config.Controlplane.PublicNet = opts.ControlplanePublicNet
+	cl.Config.Controlplane.ImageSnapshot = opts.ControlplaneImageSnapshot
+	cl.Config.Controlplane.Locations = opts.ControlplaneLocations
+	cl.Config.ControlplaneEndpoint = opts.ControlplaneEndpoint
+	cl.Config.ControlplaneLoadBalancer.Type = opts.ControlplaneLoadBalancerType
+	cl.Config.ControlplaneLoadBalancer.Algorithm = opts.ControlplaneLoadBalancerAlgorithm
+	cl.Config.ControlplaneLoadBalancer.HealthCheck = opts.ControlplaneLoadBalancerHealthCheck
+	cl.Config.Ingress.Enabled = opts.Ingress
+	if opts.Ingress {
+		cl.Config.Ingress.Pool = opts.IngressPool
+		cl.Config.Ingress.LoadBalancerType = opts.IngressLoadBalancerType
+		cl.Config.Ingress.ProxyProtocol = opts.IngressProxyProtocol
+		// the ingress pool is declared right away, so that its taints and
+		// further settings can be configured next to it
+		if opts.IngressPool != "" {
+			cl.Config.Pools = append(cl.Config.Pools, cluster.ConfigPool{Name: opts.IngressPool})
+		}
+	}
+	if cl.Config.IsPrivateEndpoint() {
+		cl.Config.Bastion.ServerType = opts.BastionServerType
+		cl.Config.Bastion.Port = opts.BastionPort
+		cl.Config.Bastion.Subnet = opts.BastionSubnet
+		cl.Config.Bastion.PrivateKey, err = utils.WireguardGenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		cl.Config.Bastion.ClientPrivateKey, err = utils.WireguardGenerateKey()
+		if err != nil {
+			return nil, err
+		}
 	}
 	logger.Info.Printf("Bootstrapping cluster %s (talos %s, kubernetes %s)\n", cl.Config.ClusterName, opts.TalosVersion, opts.KubernetesVersion)
 	if opts.ClusterName == "" {
-		return fmt.Errorf("cluster name must not be empty")
+		return nil, fmt.Errorf("cluster name must not be empty")
 	}
 	if opts.NodeName == "" {
-		return fmt.Errorf("node name must not be empty")
+		return nil, fmt.Errorf("node name must not be empty")
 	}
 	if opts.ServerType == "" {
-		return fmt.Errorf("node server type must not be empty")
+		return nil, fmt.Errorf("node server type must not be empty")
 	}
 	if opts.Location == "" {
-		return fmt.Errorf("location must not be empty")
+		return nil, fmt.Errorf("location must not be empty")
 	}
 	if opts.NetworkZone == "" {
-		return fmt.Errorf("network zone must not be empty")
+		return nil, fmt.Errorf("network zone must not be empty")
 	}
 	if opts.Token == "" {
-		return fmt.Errorf("token must not be empty")
+		return nil, fmt.Errorf("token must not be empty")
 	}
 	if opts.TalosVersion == "" {
-		return fmt.Errorf("talos version must not be empty")
+		return nil, fmt.Errorf("talos version must not be empty")
 	}
 	if opts.KubernetesVersion == "" {
-		return fmt.Errorf("kubernetes version must not be empty")
+		return nil, fmt.Errorf("kubernetes version must not be empty")
+	}
+	if opts.Cni != "flannel" && opts.Cni != "cilium" {
+		return nil, fmt.Errorf("cni must be one of flannel or cilium")
+	}
+	if opts.CiliumKubeProxyReplacement && opts.Cni != "cilium" {
+		return nil, fmt.Errorf("kube-proxy replacement requires cilium")
+	}
+	if opts.PodRouting != "overlay" && opts.PodRouting != "native" {
+		return nil, fmt.Errorf("pod routing must be one of overlay or native")
+	}
+	if opts.PodRouting == "native" && opts.Cni != "cilium" {
+		return nil, fmt.Errorf("native pod routing requires cilium")
+	}
+	if opts.DualStack && opts.Cni != "cilium" {
+		return nil, fmt.Errorf("dual-stack requires cilium")
+	}
+	if opts.DualStack && opts.PodRouting != "overlay" {
+		return nil, fmt.Errorf("dual-stack requires overlay pod routing")
+	}
+	if err := cl.Config.Network.Validate(); err != nil {
+		return nil, err
+	}
+	if existingNetwork != nil {
+		if err := validateNativePodSubnet(cl, existingNetwork); err != nil {
+			return nil, err
+		}
+	}
+	if err := cl.Config.ControlplaneLoadBalancer.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cl.Config.Ingress.Validate(cl.Config.Pools); err != nil {
+		return nil, err
+	}
+	if err := validatePool(cl, cl.Config.Controlplane); err != nil {
+		return nil, err
+	}
+	if opts.ControlplaneEndpoint != "load-balancer" && opts.ControlplaneEndpoint != "floating-ip" && opts.ControlplaneEndpoint != "private-load-balancer" {
+		return nil, fmt.Errorf("controlplane endpoint must be one of load-balancer, floating-ip or private-load-balancer")
+	}
+	if cl.Config.IsFloatingIPEndpoint() && !cl.Config.Controlplane.HasPublicIPv4() {
+		return nil, fmt.Errorf("floating IP controlplane endpoint requires controlplane nodes with public IPv4")
+	}
+	if cl.Config.IsPrivateEndpoint() {
+		if err := cl.Config.Bastion.Validate(cl.Config.Network); err != nil {
+			return nil, err
+		}
 	}
 
-	network, err := clients.HcloudEnsureNetwork(cl, nodeNetworkTemplate(cl), true)
+	if !opts.NoPreflight {
+		nodeCount := 1
+		infraServerTypes := []string{}
+		if opts.NatGateway {
+			nodeCount++
+			infraServerTypes = append(infraServerTypes, opts.NatGatewayType)
+		}
+		if cl.Config.IsPrivateEndpoint() {
+			nodeCount++
+			infraServerTypes = append(infraServerTypes, opts.BastionServerType)
+		}
+		err := preflight(cl, preflightChecks{
+			ServerType:        opts.ServerType,
+			Pool:              cl.Config.Controlplane,
+			NodeCount:         nodeCount,
+			TalosVersion:      opts.TalosVersion,
+			KubernetesVersion: opts.KubernetesVersion,
+			InfraServerTypes:  infraServerTypes,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the config is only written once nothing can fail anymore without
+	// creating resources, so that a failed bootstrap can simply be re-run
+	err = cl.Save(opts.ConfigFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	controlplanePlacementGroup, err := clients.HcloudEnsurePlacementGroup(cl, controlplanePlacementGroupTemplate(cl), true)
+	network, err := ensureNodeNetwork(cl, true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	controlplaneLoadBalancer, err := clients.HcloudEnsureLoadBalancer(cl, network, controlplaneLoadBalanacerTemplate(cl, network), true)
+	controlplaneIP, controlplaneIP6, err := ensureControlplaneEndpoint(cl, network, true, false)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if cl.Config.IsPrivateEndpoint() {
+		bastion, _, err := ensureBastion(cl, network, true, false)
+		if err != nil {
+			return nil, err
+		}
+		err = waitBastionTunnel(cl, bastion, bastionTunnelTimeout)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if !opts.NoFirewall {
-		_, err = clients.HcloudEnsureFirewall(cl, nodeFirewallTemplate(cl, network), true)
+		tmpl, err := nodeFirewallTemplate(cl, network)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		_, _, err = clients.HcloudEnsureFirewall(cl, tmpl, true, false)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	_, err = TalosGenConfig(cl, network, opts.ClusterName, controlplaneLoadBalancer.PublicNet.IPv4.IP, opts.KubernetesVersion, !opts.NoTalosKubespan)
-	if err != nil {
-		return err
+	if opts.NatGateway {
+		_, err = ensureNatGateway(cl, network, true)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	controlplaneNodeTemplate, err := controlplaneNodeTemplate(cl, opts.ServerType, opts.NodeName, opts.TalosVersion)
+	additionalSANs := []net.IP{}
+	if opts.DualStack && controlplaneIP6 != nil {
+		additionalSANs = append(additionalSANs, controlplaneIP6)
+	}
+	_, err = TalosGenConfig(cl, network, opts.ClusterName, controlplaneIP, additionalSANs, opts.KubernetesVersion, !opts.NoTalosKubespan)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	controlplaneServer, err := clients.HcloudCreateServerFromImage(cl, network, controlplanePlacementGroup, controlplaneNodeTemplate)
+
+	controlplaneServer, err = createNodeServer(cl, network, opts.ServerType, true, "", opts.NodeName, opts.TalosVersion)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	controlplaneServerPrivateIP := controlplaneServer.PrivateNet[0].IP
 
+	_, err = reconcileFirewalls(cl, network, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ensureControlplaneFloatingIPAssigned(cl, 0)
+	if err != nil {
+		return nil, err
+	}
+
 	err = utils.RetrySlow(logger, func() error {
 		_, err := TalosBootstrap(cl, controlplaneServerPrivateIP)
 		return err
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = utils.Retry(logger, func() error {
@@ -110,37 +315,58 @@ func BootstrapCluster(logger *utils.Logger, dir string, opts BootstrapClusterOpt
 		return err
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = clients.KubernetesWaitNodeRegistered(cl, controlplaneServer.Name)
 	if err != nil {
-		return err
-	}
-
-	err = utils.Retry(logger, func() error {
-		return TalosPatchFlannelDaemonSet(cl, `
-			[
-				{
-					"op": "add",
-					"path": "/spec/template/spec/containers/0/args/-",
-					"value": "--iface=eth1"
-				}
-			]
-		`)
-	})
-	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = ApplyManifests(logger, dir, ApplyManifestsOpts{
+	applyManifestsResult, err = ApplyManifests(logger, dir, ApplyManifestsOpts{
 		ConfigFile:                     opts.ConfigFile,
 		NoHcloudCloudControllerManager: opts.NoHcloudCloudControllerManager,
 		NoHcloudCsiDriver:              opts.NoHcloudCsiDriver,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	result = newResult(cl, start)
+	result.addNode(controlplaneServer, time.Time{})
+	result.merge(applyManifestsResult)
+	return result, nil
+}
+
+// bastionTunnelTimeout is how long bootstrap waits for the operator to bring
+// up the WireGuard tunnel to a new bastion.
+const bastionTunnelTimeout = 15 * time.Minute
+
+// waitBastionTunnel waits until the operator has connected to the bastion,
+// as everything after it talks to the private IPs of the nodes. The tunnel is
+// up once the SSH port of the bastion answers on its private IP, which is
+// only routed through WireGuard.
+func waitBastionTunnel(cl *cluster.Cluster, bastion *hcloud.Server, timeout time.Duration) error {
+	wireguardConfigFile, err := filepath.Abs(path.Join(cl.Dir, "wireguard.conf"))
+	if err != nil {
+		return err
+	}
+	address := net.JoinHostPort(bastion.PrivateNet[0].IP.String(), "22")
+	deadline := time.Now().Add(timeout)
+	nextMessage := time.Now()
+	for time.Now().Before(deadline) {
+		if !time.Now().Before(nextMessage) {
+			cl.Logger.Info.Printf("The controlplane is only reachable through the bastion, connect with: wg-quick up %s\n", wireguardConfigFile)
+			nextMessage = time.Now().Add(time.Minute)
+		}
+		conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+		if err == nil {
+			conn.Close()
+			cl.Logger.Info.Printf("Bastion is reachable through WireGuard\n")
+			return nil
+		}
+		cl.Logger.Debug.Printf("Bastion is not yet reachable: %v\n", err)
+		time.Sleep(5 * time.Second)
+	}
+	return fmt.Errorf("bastion %s was not reachable through WireGuard within %s, run destroy-cluster --force and bootstrap again after connecting with: wg-quick up %s", address, timeout, wireguardConfigFile)
 }
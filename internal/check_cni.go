@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+type CheckCniOpts struct {
+	ConfigFile string
+	Repair     bool
+}
+
+func CheckCni(logger *utils.Logger, dir string, opts CheckCniOpts) (result *Result, err error) {
+	start := time.Now()
+	cl := &cluster.Cluster{Dir: dir}
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Checking CNI configuration of cluster %s\n", cl.Config.ClusterName)
+	if cl.Config.Cni.IsCilium() {
+		logger.Info.Printf("Cluster uses cilium, nothing to check\n")
+		return newResult(cl, start), nil
+	}
+
+	drift := false
+	flannelIfaceArg := talosFlannelIfaceArg(cl)
+
+	controlplaneConfigFile := path.Join(cl.Dir, "controlplane.yaml")
+	controlplaneConfig, err := os.ReadFile(controlplaneConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	extraArgs, err := TalosConfigFlannelExtraArgs(controlplaneConfig)
+	if err != nil {
+		return nil, err
+	}
+	if flannelIfaceArgsDrift(extraArgs, flannelIfaceArg) {
+		drift = true
+		logger.Warn.Printf("controlplane.yaml does not bind flannel with argument %s\n", flannelIfaceArg)
+		if opts.Repair {
+			logger.Info.Printf("Setting flannel argument %s in controlplane.yaml\n", flannelIfaceArg)
+			repairedArgs, err := json.Marshal(flannelIfaceArgsRepaired(extraArgs, flannelIfaceArg))
+			if err != nil {
+				return nil, err
+			}
+			patchedConfig, err := TalosPatchConfig(cl, "controlplane.yaml", fmt.Sprintf(`
+				[
+					{
+						"op": "add",
+						"path": "/cluster/network/cni",
+						"value": {
+							"name": "flannel",
+							"flannel": {
+								"extraArgs": %s
+							}
+						}
+					}
+				]
+			`, repairedArgs))
+			if err != nil {
+				return nil, err
+			}
+			err = os.WriteFile(controlplaneConfigFile, []byte(patchedConfig), 0o600)
+			if err != nil {
+				return nil, err
+			}
+			cl.RecordUpdated("talos-config", "controlplane.yaml", 0)
+		}
+	}
+
+	servers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=controlplane",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	driftedServers := []*hcloud.Server{}
+	for _, server := range servers {
+		if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
+			return nil, fmt.Errorf("server %q private IP could not be determined", server.Name)
+		}
+		runningConfig, err := TalosReadConfig(cl, server.PrivateNet[0].IP)
+		if err != nil {
+			return nil, err
+		}
+		extraArgs, err := TalosConfigFlannelExtraArgs([]byte(runningConfig))
+		if err != nil {
+			return nil, err
+		}
+		if flannelIfaceArgsDrift(extraArgs, flannelIfaceArg) {
+			drift = true
+			logger.Warn.Printf("Running config of node %s does not bind flannel with argument %s\n", server.Name, flannelIfaceArg)
+			driftedServers = append(driftedServers, server)
+		}
+	}
+	if opts.Repair && len(driftedServers) > 0 {
+		err := applyConfigToServers(cl, driftedServers, "auto", false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	daemonSetArgs, err := TalosFlannelDaemonSetArgs(cl)
+	if err != nil {
+		return nil, err
+	}
+	if flannelIfaceArgsDrift(daemonSetArgs, flannelIfaceArg) {
+		drift = true
+		logger.Warn.Printf("Flannel daemon set does not bind flannel with argument %s\n", flannelIfaceArg)
+		if opts.Repair {
+			logger.Info.Printf("Patching flannel daemon set\n")
+			repairedArgs, err := json.Marshal(flannelIfaceArgsRepaired(daemonSetArgs, flannelIfaceArg))
+			if err != nil {
+				return nil, err
+			}
+			err = utils.Retry(logger, func() error {
+				return TalosPatchFlannelDaemonSet(cl, fmt.Sprintf(`
+					[
+						{
+							"op": "replace",
+							"path": "/spec/template/spec/containers/0/args",
+							"value": %s
+						}
+					]
+				`, repairedArgs))
+			})
+			if err != nil {
+				return nil, err
+			}
+			cl.RecordUpdated("daemon-set", "kube-system/kube-flannel", 0)
+		}
+	}
+
+	if drift && !opts.Repair {
+		return nil, fmt.Errorf("CNI configuration drift detected, rerun with --repair to fix it")
+	}
+	if !drift {
+		logger.Info.Printf("CNI configuration is up to date\n")
+	}
+	result = newResult(cl, start)
+	for _, server := range driftedServers {
+		result.addNode(server, time.Time{})
+	}
+	return result, nil
+}
+
+// flannelIfaceArgsDrift tells whether flannel is not bound by the expected
+// argument, or also by another one that would take precedence, like the
+// fixed interface name of older clusters.
+func flannelIfaceArgsDrift(args []string, expected string) bool {
+	for _, arg := range args {
+		if arg != expected && isFlannelIfaceArg(arg) {
+			return true
+		}
+	}
+	return !slices.Contains(args, expected)
+}
+
+// flannelIfaceArgsRepaired replaces all interface arguments with the expected
+// one, keeping the other arguments.
+func flannelIfaceArgsRepaired(args []string, expected string) []string {
+	result := []string{}
+	for _, arg := range args {
+		if isFlannelIfaceArg(arg) {
+			continue
+		}
+		result = append(result, arg)
+	}
+	return append(result, expected)
+}
+
+func isFlannelIfaceArg(arg string) bool {
+	return strings.HasPrefix(arg, "--iface=") || strings.HasPrefix(arg, "--iface-can-reach=") || strings.HasPrefix(arg, "--iface-regex=")
+}
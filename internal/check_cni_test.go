@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlannelIfaceArgs(t *testing.T) {
+	expected := "--iface-can-reach=10.0.0.1"
+	tests := []struct {
+		name     string
+		args     []string
+		drift    bool
+		repaired []string
+	}{
+		{
+			name:     "up to date",
+			args:     []string{"--ip-masq", expected},
+			drift:    false,
+			repaired: []string{"--ip-masq", expected},
+		},
+		{
+			name:     "up to date in other order",
+			args:     []string{expected, "--ip-masq"},
+			drift:    false,
+			repaired: []string{"--ip-masq", expected},
+		},
+		{
+			name:     "missing",
+			args:     []string{"--ip-masq"},
+			drift:    true,
+			repaired: []string{"--ip-masq", expected},
+		},
+		{
+			name:     "fixed interface name",
+			args:     []string{"--ip-masq", "--iface=eth1"},
+			drift:    true,
+			repaired: []string{"--ip-masq", expected},
+		},
+		{
+			name:     "fixed interface name next to expected",
+			args:     []string{"--iface=eth1", expected},
+			drift:    true,
+			repaired: []string{expected},
+		},
+		{
+			name:     "other gateway",
+			args:     []string{"--iface-can-reach=10.1.0.1"},
+			drift:    true,
+			repaired: []string{expected},
+		},
+		{
+			name:     "empty",
+			args:     nil,
+			drift:    true,
+			repaired: []string{expected},
+		},
+	}
+	for _, test := range tests {
+		if actual := flannelIfaceArgsDrift(test.args, expected); actual != test.drift {
+			t.Errorf("%s: flannelIfaceArgsDrift = %v, expected %v", test.name, actual, test.drift)
+		}
+		if actual := flannelIfaceArgsRepaired(test.args, expected); !reflect.DeepEqual(actual, test.repaired) {
+			t.Errorf("%s: flannelIfaceArgsRepaired = %v, expected %v", test.name, actual, test.repaired)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+type CheckDriftOpts struct {
+	ConfigFile string
+	Repair     bool
+}
+
+// CheckDrift compares the hcloud resources of the cluster with their
+// templates. Missing resources are reported as drift as well, but never
+// created.
+func CheckDrift(logger *utils.Logger, dir string, opts CheckDriftOpts) (result *Result, err error) {
+	start := time.Now()
+	cl := &cluster.Cluster{Dir: dir}
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Checking hcloud resources of cluster %s\n", cl.Config.ClusterName)
+	if err := cl.Config.ControlplaneLoadBalancer.Validate(); err != nil {
+		return nil, err
+	}
+
+	drifts := 0
+	report := func(err error) {
+		if err != nil {
+			logger.Warn.Printf("Drift: %v\n", err)
+			drifts++
+		}
+	}
+
+	var network *hcloud.Network
+	var networkDrifts int
+	if cl.Config.Network.ExistingNetwork != "" {
+		network, networkDrifts, err = clients.HcloudEnsureNetworkSubnets(cl, nodeNetworkTemplate(cl), opts.Repair)
+	} else {
+		network, networkDrifts, err = clients.HcloudEnsureNetwork(cl, nodeNetworkTemplate(cl), false, opts.Repair)
+	}
+	if err != nil {
+		return nil, err
+	}
+	drifts += networkDrifts
+
+	for _, location := range cl.Config.PoolLocations(cl.Config.Controlplane) {
+		tmpl := controlplanePlacementGroupTemplate(cl, location)
+		placementGroup, _, err := cl.Client.PlacementGroup.Get(*cl.Ctx, tmpl.Name)
+		if err != nil {
+			report(err)
+			continue
+		}
+		// placement groups of further locations are only created with their first node
+		if placementGroup == nil && location != cl.Config.Hcloud.Location {
+			continue
+		}
+		_, placementGroupDrifts, err := clients.HcloudEnsurePlacementGroup(cl, tmpl, false, opts.Repair)
+		drifts += placementGroupDrifts
+		report(err)
+	}
+
+	if cl.Config.IsFloatingIPEndpoint() {
+		_, err = clients.HcloudEnsureFloatingIP(cl, controlplaneFloatingIPTemplate(cl), false)
+		report(err)
+		if opts.Repair {
+			report(ensureControlplaneFloatingIPAssigned(cl, 0))
+		}
+	} else {
+		_, loadBalancerDrifts, err := clients.HcloudEnsureLoadBalancer(cl, network, controlplaneLoadBalanacerTemplate(cl, network), false, opts.Repair)
+		drifts += loadBalancerDrifts
+		report(err)
+	}
+
+	firewallDrifts, err := reconcileFirewalls(cl, network, opts.Repair)
+	drifts += firewallDrifts
+	report(err)
+
+	if cl.Config.Ingress.Enabled {
+		_, loadBalancerDrifts, err := clients.HcloudEnsureLoadBalancer(cl, network, ingressLoadBalancerTemplate(cl, network), false, opts.Repair)
+		drifts += loadBalancerDrifts
+		report(err)
+	}
+
+	if cl.Config.IsPrivateEndpoint() {
+		_, bastionDrifts, err := ensureBastion(cl, network, false, opts.Repair)
+		drifts += bastionDrifts
+		report(err)
+	}
+
+	if cl.Config.Network.NatGateway {
+		_, err = ensureNatGateway(cl, network, false)
+		report(err)
+	}
+
+	if drifts > 0 && !opts.Repair {
+		return nil, fmt.Errorf("%d differences detected, rerun with --repair to fix them", drifts)
+	}
+	if drifts > 0 {
+		return nil, fmt.Errorf("%d differences could not be repaired in place", drifts)
+	}
+	logger.Info.Printf("Hcloud resources are up to date\n")
+	return newResult(cl, start), nil
+}
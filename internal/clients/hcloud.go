@@ -10,59 +10,145 @@ import (
 	"github.com/hetznercloud/hcloud-go/hcloud/schema"
 )
 
-func HcloudEnsureNetwork(cl *cluster.Cluster, tmpl hcloud.NetworkCreateOpts, create bool) (*hcloud.Network, error) {
+// HcloudEnsureNetwork ensures that the network exists. Drift of an existing
+// network is reported and, if update is set, repaired.
+func HcloudEnsureNetwork(cl *cluster.Cluster, tmpl hcloud.NetworkCreateOpts, create bool, update bool) (*hcloud.Network, int, error) {
 	network, _, err := cl.Client.Network.Get(*cl.Ctx, tmpl.Name)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if network != nil {
-		return network, nil
+		drifts, err := hcloudReconcileNetwork(cl, network, tmpl, update)
+		if err != nil {
+			return nil, drifts, err
+		}
+		if update {
+			network, _, err = cl.Client.Network.GetByID(*cl.Ctx, network.ID)
+			if err != nil {
+				return nil, drifts, err
+			}
+		}
+		return network, drifts, nil
 	}
 	if !create {
-		return nil, fmt.Errorf("network %q could not be found", tmpl.Name)
+		return nil, 0, fmt.Errorf("network %q could not be found", tmpl.Name)
 	}
 
 	cl.Logger.Info.Printf("Creating new network %q\n", tmpl.Name)
 	network, _, err = cl.Client.Network.Create(*cl.Ctx, tmpl)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	cl.RecordCreated("network", network.Name, network.ID)
 
-	return network, nil
+	return network, 0, nil
 }
 
-func HcloudEnsurePlacementGroup(cl *cluster.Cluster, tmpl hcloud.PlacementGroupCreateOpts, create bool) (*hcloud.PlacementGroup, error) {
+// HcloudEnsureNetworkSubnets ensures that a network not managed by us exists
+// and contains all subnets of the template. Other subnets of the network are
+// left untouched. Without create, missing subnets are reported as drift.
+func HcloudEnsureNetworkSubnets(cl *cluster.Cluster, tmpl hcloud.NetworkCreateOpts, create bool) (*hcloud.Network, int, error) {
+	network, _, err := cl.Client.Network.Get(*cl.Ctx, tmpl.Name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if network == nil {
+		return nil, 0, fmt.Errorf("existing network %q could not be found", tmpl.Name)
+	}
+
+	added := false
+	drifts := 0
+	for _, subnet := range tmpl.Subnets {
+		found := false
+		for _, existingSubnet := range network.Subnets {
+			if existingSubnet.IPRange.String() == subnet.IPRange.String() {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if !create {
+			cl.Logger.Warn.Printf("Drift of network %q: subnet %s is missing\n", network.Name, subnet.IPRange)
+			drifts++
+			continue
+		}
+		cl.Logger.Info.Printf("Adding subnet %s to existing network %q\n", subnet.IPRange, network.Name)
+		action, _, err := cl.Client.Network.AddSubnet(*cl.Ctx, network, hcloud.NetworkAddSubnetOpts{
+			Subnet: subnet,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		err = cl.Client.Action.WaitFor(*cl.Ctx, action)
+		if err != nil {
+			return nil, 0, err
+		}
+		added = true
+	}
+	if added {
+		cl.RecordUpdated("network", network.Name, network.ID)
+		network, _, err = cl.Client.Network.GetByID(*cl.Ctx, network.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return network, drifts, nil
+}
+
+// HcloudEnsurePlacementGroup ensures that the placement group exists. Drift
+// of an existing placement group is reported and, if update is set, repaired.
+func HcloudEnsurePlacementGroup(cl *cluster.Cluster, tmpl hcloud.PlacementGroupCreateOpts, create bool, update bool) (*hcloud.PlacementGroup, int, error) {
 	placementGroup, _, err := cl.Client.PlacementGroup.Get(*cl.Ctx, tmpl.Name)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if placementGroup != nil {
-		return placementGroup, nil
+		drifts, err := hcloudReconcilePlacementGroup(cl, placementGroup, tmpl, update)
+		if err != nil {
+			return nil, drifts, err
+		}
+		return placementGroup, drifts, nil
 	}
 	if !create {
-		return nil, fmt.Errorf("placement group %q could not be found", tmpl.Name)
+		return nil, 0, fmt.Errorf("placement group %q could not be found", tmpl.Name)
 	}
 
 	cl.Logger.Info.Printf("Creating new placement group %q\n", tmpl.Name)
 	placementGroupResult, _, err := cl.Client.PlacementGroup.Create(*cl.Ctx, tmpl)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	placementGroup = placementGroupResult.PlacementGroup
+	cl.RecordCreated("placement-group", placementGroup.Name, placementGroup.ID)
 
-	return placementGroup, nil
+	return placementGroup, 0, nil
 }
 
-func HcloudEnsureLoadBalancer(cl *cluster.Cluster, network *hcloud.Network, tmpl hcloud.LoadBalancerCreateOpts, create bool) (*hcloud.LoadBalancer, error) {
+// HcloudEnsureLoadBalancer ensures that the load balancer exists. Drift of an
+// existing load balancer is reported and, if update is set, repaired.
+func HcloudEnsureLoadBalancer(cl *cluster.Cluster, network *hcloud.Network, tmpl hcloud.LoadBalancerCreateOpts, create bool, update bool) (*hcloud.LoadBalancer, int, error) {
 	loadBalancer, _, err := cl.Client.LoadBalancer.Get(*cl.Ctx, tmpl.Name)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if loadBalancer != nil {
-		return loadBalancer, nil
+		drifts, err := hcloudReconcileLoadBalancer(cl, loadBalancer, tmpl, update)
+		if err != nil {
+			return nil, drifts, err
+		}
+		if update {
+			loadBalancer, _, err = cl.Client.LoadBalancer.GetByID(*cl.Ctx, loadBalancer.ID)
+			if err != nil {
+				return nil, drifts, err
+			}
+		}
+		return loadBalancer, drifts, nil
 	}
 	if !create {
-		return nil, fmt.Errorf("load balancer %q could not be found", tmpl.Name)
+		return nil, 0, fmt.Errorf("load balancer %q could not be found", tmpl.Name)
 	}
 
 	targets := tmpl.Targets
@@ -71,9 +157,10 @@ func HcloudEnsureLoadBalancer(cl *cluster.Cluster, network *hcloud.Network, tmpl
 	cl.Logger.Info.Printf("Creating new load balancer %q\n", tmpl.Name)
 	loadBalancerResult, _, err := cl.Client.LoadBalancer.Create(*cl.Ctx, tmpl)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	loadBalancer = loadBalancerResult.LoadBalancer
+	cl.RecordCreated("load-balancer", loadBalancer.Name, loadBalancer.ID)
 
 	cl.Logger.Debug.Printf("Waiting for load balancer to aquire IPs\n")
 	err = utils.Retry(cl.Logger, func() error {
@@ -81,7 +168,8 @@ func HcloudEnsureLoadBalancer(cl *cluster.Cluster, network *hcloud.Network, tmpl
 		if err != nil {
 			return err
 		}
-		if !loadBalancer.PublicNet.Enabled || loadBalancer.PublicNet.IPv4.IP.Equal(net.IP{}) {
+		publicInterface := tmpl.PublicInterface == nil || *tmpl.PublicInterface
+		if publicInterface && (!loadBalancer.PublicNet.Enabled || loadBalancer.PublicNet.IPv4.IP.Equal(net.IP{})) {
 			return fmt.Errorf("load balancer does not yet have a public IP")
 		}
 		if tmpl.Network != nil && (len(loadBalancer.PrivateNet) == 0 || loadBalancer.PrivateNet[0].IP.Equal(net.IP{})) {
@@ -90,7 +178,7 @@ func HcloudEnsureLoadBalancer(cl *cluster.Cluster, network *hcloud.Network, tmpl
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	cl.Logger.Debug.Printf("Load balancer IPs are %v and %v\n", loadBalancer.PublicNet.IPv4.IP, loadBalancer.PrivateNet[0].IP)
 
@@ -121,33 +209,166 @@ func HcloudEnsureLoadBalancer(cl *cluster.Cluster, network *hcloud.Network, tmpl
 			}
 		})
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
-	return loadBalancer, nil
+	return loadBalancer, 0, nil
 }
 
-func HcloudEnsureFirewall(cl *cluster.Cluster, tmpl hcloud.FirewallCreateOpts, create bool) (*hcloud.Firewall, error) {
-	firewall, _, err := cl.Client.Firewall.Get(*cl.Ctx, tmpl.Name)
+func HcloudEnsureFloatingIP(cl *cluster.Cluster, tmpl hcloud.FloatingIPCreateOpts, create bool) (*hcloud.FloatingIP, error) {
+	floatingIP, _, err := cl.Client.FloatingIP.Get(*cl.Ctx, *tmpl.Name)
+	if err != nil {
+		return nil, err
+	}
+	if floatingIP != nil {
+		return floatingIP, nil
+	}
+	if !create {
+		return nil, fmt.Errorf("floating IP %q could not be found", *tmpl.Name)
+	}
+
+	cl.Logger.Info.Printf("Creating new floating IP %q\n", *tmpl.Name)
+	floatingIPResult, _, err := cl.Client.FloatingIP.Create(*cl.Ctx, tmpl)
 	if err != nil {
 		return nil, err
 	}
+	floatingIP = floatingIPResult.FloatingIP
+	cl.RecordCreated("floating-ip", floatingIP.Name, floatingIP.ID)
+
+	return floatingIP, nil
+}
+
+func HcloudAssignFloatingIP(cl *cluster.Cluster, floatingIP *hcloud.FloatingIP, server *hcloud.Server) error {
+	cl.Logger.Info.Printf("Assigning floating IP %q to server %q\n", floatingIP.Name, server.Name)
+	action, _, err := cl.Client.FloatingIP.Assign(*cl.Ctx, floatingIP, server)
+	if err != nil {
+		return err
+	}
+	return cl.Client.Action.WaitFor(*cl.Ctx, action)
+}
+
+// HcloudEnsureFirewall ensures that the firewall exists. Drift of an existing
+// firewall is reported and, if update is set, repaired.
+func HcloudEnsureFirewall(cl *cluster.Cluster, tmpl hcloud.FirewallCreateOpts, create bool, update bool) (*hcloud.Firewall, int, error) {
+	firewall, _, err := cl.Client.Firewall.Get(*cl.Ctx, tmpl.Name)
+	if err != nil {
+		return nil, 0, err
+	}
 	if firewall != nil {
-		return firewall, nil
+		drifts, err := hcloudReconcileFirewall(cl, firewall, tmpl, update)
+		if err != nil {
+			return nil, drifts, err
+		}
+		return firewall, drifts, nil
 	}
 	if !create {
-		return nil, fmt.Errorf("firewall %q could not be found", tmpl.Name)
+		return nil, 0, fmt.Errorf("firewall %q could not be found", tmpl.Name)
 	}
 
 	cl.Logger.Info.Printf("Creating new firewall %q\n", tmpl.Name)
 	firewallResult, _, err := cl.Client.Firewall.Create(*cl.Ctx, tmpl)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	firewall = firewallResult.Firewall
+	cl.RecordCreated("firewall", firewall.Name, firewall.ID)
+
+	return firewall, 0, nil
+}
+
+func HcloudSetFirewallRules(cl *cluster.Cluster, firewall *hcloud.Firewall, rules []hcloud.FirewallRule) error {
+	cl.Logger.Debug.Printf("Updating rules of firewall %q\n", firewall.Name)
+	actions, _, err := cl.Client.Firewall.SetRules(*cl.Ctx, firewall, hcloud.FirewallSetRulesOpts{
+		Rules: rules,
+	})
+	if err != nil {
+		return err
+	}
+	return cl.Client.Action.WaitFor(*cl.Ctx, actions...)
+}
+
+// HcloudEnsureNetworkRoute ensures that the network contains the route. A
+// route to the same destination via another gateway is never replaced.
+func HcloudEnsureNetworkRoute(cl *cluster.Cluster, network *hcloud.Network, route hcloud.NetworkRoute, create bool) error {
+	for _, existingRoute := range network.Routes {
+		if existingRoute.Destination.String() != route.Destination.String() {
+			continue
+		}
+		if !existingRoute.Gateway.Equal(route.Gateway) {
+			return fmt.Errorf("network %q already routes %s via %s instead of %s", network.Name, route.Destination, existingRoute.Gateway, route.Gateway)
+		}
+		return nil
+	}
+	if !create {
+		return fmt.Errorf("network %q does not contain route %s", network.Name, route.Destination)
+	}
+
+	cl.Logger.Info.Printf("Adding route %s via %s to network %q\n", route.Destination, route.Gateway, network.Name)
+	action, _, err := cl.Client.Network.AddRoute(*cl.Ctx, network, hcloud.NetworkAddRouteOpts{
+		Route: route,
+	})
+	if err != nil {
+		return err
+	}
+	return cl.Client.Action.WaitFor(*cl.Ctx, action)
+}
+
+// HcloudEnsureServer ensures that a plain server (in contrast to a Talos node)
+// exists and has a private IP.
+func HcloudEnsureServer(cl *cluster.Cluster, tmpl hcloud.ServerCreateOpts, create bool) (*hcloud.Server, error) {
+	server, _, err := cl.Client.Server.Get(*cl.Ctx, tmpl.Name)
+	if err != nil {
+		return nil, err
+	}
+	if server == nil && !create {
+		return nil, fmt.Errorf("server %q could not be found", tmpl.Name)
+	}
+	if server == nil {
+		cl.Logger.Info.Printf("Creating new server %q\n", tmpl.Name)
+		sshKeyPrivate := SSHKeyPrivate{}
+		if err := sshKeyPrivate.Generate(); err != nil {
+			return nil, err
+		}
+		sshKeyPublic, err := sshKeyPrivate.StorePublic()
+		if err != nil {
+			return nil, err
+		}
+		sshKey, _, err := cl.Client.SSHKey.Create(*cl.Ctx, hcloud.SSHKeyCreateOpts{
+			Name:      tmpl.Name + "-init-" + utils.RandString(8),
+			PublicKey: sshKeyPublic,
+			Labels:    tmpl.Labels,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			cl.Client.SSHKey.Delete(*cl.Ctx, sshKey)
+		}()
+		tmpl.SSHKeys = []*hcloud.SSHKey{sshKey} // not needed, but without Hetzner sends out server creation email
+		serverResponse, _, err := cl.Client.Server.Create(*cl.Ctx, tmpl)
+		if err != nil {
+			return nil, err
+		}
+		server = serverResponse.Server
+		cl.RecordCreated("server", server.Name, server.ID)
+	}
+
+	err = utils.Retry(cl.Logger, func() error {
+		server, _, err = cl.Client.Server.GetByID(*cl.Ctx, server.ID)
+		if err != nil {
+			return err
+		}
+		if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
+			return fmt.Errorf("server does not yet have a private IP")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return firewall, nil
+	return server, nil
 }
 
 type HcloudServerCreateFromImageOpts struct {
@@ -157,9 +378,66 @@ type HcloudServerCreateFromImageOpts struct {
 	BaseLabels     map[string]string
 	FinalizeLabels map[string]string
 	ImageTarXzUrl  string
+	ImageSnapshot  string
+	Location       string
+	PublicIPv4     bool
+	PublicIPv6     bool
+}
+
+// HcloudCapacityError is returned when hcloud can currently not create a
+// server of the server type in the location, so that another server type or
+// location can be tried.
+type HcloudCapacityError struct {
+	ServerType string
+	Location   string
+	Err        error
+}
+
+func (e *HcloudCapacityError) Error() string {
+	return fmt.Sprintf("server type %s is not available in location %s: %v", e.ServerType, e.Location, e.Err)
+}
+
+func (e *HcloudCapacityError) Unwrap() error {
+	return e.Err
+}
+
+// hcloudErrorCodeUnsupportedLocationForServerType is not yet known to hcloud-go
+const hcloudErrorCodeUnsupportedLocationForServerType = hcloud.ErrorCode("unsupported_location_for_server_type")
+
+func hcloudServerCreateError(tmpl HcloudServerCreateFromImageOpts, err error) error {
+	if hcloud.IsError(err, hcloud.ErrorCodeResourceUnavailable) ||
+		hcloud.IsError(err, hcloud.ErrorCodePlacementError) ||
+		hcloud.IsError(err, hcloudErrorCodeUnsupportedLocationForServerType) {
+		return &HcloudCapacityError{ServerType: tmpl.ServerType, Location: tmpl.Location, Err: err}
+	}
+	return err
+}
+
+// HcloudValidateLocations ensures that all locations exist and belong to the
+// network zone of the cluster, as servers can only join networks of their zone.
+func HcloudValidateLocations(cl *cluster.Cluster, locations []string) error {
+	for _, name := range locations {
+		location, _, err := cl.Client.Location.Get(*cl.Ctx, name)
+		if err != nil {
+			return err
+		}
+		if location == nil {
+			return fmt.Errorf("location %q could not be found", name)
+		}
+		if string(location.NetworkZone) != cl.Config.Hcloud.NetworkZone {
+			return fmt.Errorf("location %q is in network zone %s instead of %s", name, location.NetworkZone, cl.Config.Hcloud.NetworkZone)
+		}
+	}
+	return nil
 }
 
 func HcloudCreateServerFromImage(cl *cluster.Cluster, network *hcloud.Network, placementGroup *hcloud.PlacementGroup, tmpl HcloudServerCreateFromImageOpts) (*hcloud.Server, error) {
+	if tmpl.ImageSnapshot != "" {
+		return hcloudCreateServerFromSnapshot(cl, network, placementGroup, tmpl)
+	}
+	if !tmpl.PublicIPv4 && !tmpl.PublicIPv6 {
+		return nil, fmt.Errorf("server %q without public IP can only be created from an image snapshot", tmpl.Name)
+	}
 	cl.Logger.Info.Printf("Creating new server %q\n", tmpl.Name)
 	cl.Logger.Debug.Printf("Generating temporary SSH key\n")
 	sshKeyPrivate := SSHKeyPrivate{}
@@ -196,39 +474,34 @@ func HcloudCreateServerFromImage(cl *cluster.Cluster, network *hcloud.Network, p
 		}),
 		PlacementGroup: placementGroup,
 		Location: &hcloud.Location{
-			Name: cl.Config.Hcloud.Location,
+			Name: tmpl.Location,
 		},
 		Networks: []*hcloud.Network{
 			network,
 		},
+		PublicNet: &hcloud.ServerCreatePublicNet{
+			EnableIPv4: tmpl.PublicIPv4,
+			EnableIPv6: tmpl.PublicIPv6,
+		},
 		StartAfterCreate: &startAfterCreate,
 		UserData:         tmpl.UserData,
 		SSHKeys:          []*hcloud.SSHKey{sshKey}, // not needed, but without Hetzner sends out server creation email
 		Labels:           tmpl.BaseLabels,
 	})
 	if err != nil {
-		return nil, err
+		return nil, hcloudServerCreateError(tmpl, err)
 	}
+	cl.RecordCreated("server", serverRespone.Server.Name, serverRespone.Server.ID)
 
-	cl.Logger.Debug.Printf("Waiting for server to aquire IPs\n")
-	server := serverRespone.Server
-	err = utils.Retry(cl.Logger, func() error {
-		server, _, err = cl.Client.Server.GetByID(*cl.Ctx, server.ID)
-		if err != nil {
-			return err
-		}
-		if server.PublicNet.IPv4.IP.Equal(net.IP{}) {
-			return fmt.Errorf("server does not yet have a public IP")
-		}
-		if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
-			return fmt.Errorf("server does not yet have a private IP")
-		}
-		return nil
-	})
+	server, err := hcloudWaitServerIPs(cl, serverRespone.Server, tmpl)
 	if err != nil {
 		return nil, err
 	}
-	cl.Logger.Debug.Printf("Server IPs are %v and %v\n", server.PublicNet.IPv4.IP, server.PrivateNet[0].IP)
+	// the rescue system is reached through IPv6 on servers without public IPv4
+	sshIP := server.PublicNet.IPv4.IP.String()
+	if !tmpl.PublicIPv4 {
+		sshIP = hcloudServerIPv6(server).String()
+	}
 
 	cl.Logger.Debug.Printf("Starting server in rescue mode\n")
 	err = utils.Retry(cl.Logger, func() error {
@@ -249,7 +522,7 @@ func HcloudCreateServerFromImage(cl *cluster.Cluster, network *hcloud.Network, p
 		return nil, err
 	}
 	err = utils.RetrySlow(cl.Logger, func() error {
-		_, err := sshKeyPrivate.Execute(server.PublicNet.IPv4.IP.String(), 22, "true")
+		_, err := sshKeyPrivate.Execute(sshIP, 22, "true")
 		return err
 	})
 	if err != nil {
@@ -258,7 +531,7 @@ func HcloudCreateServerFromImage(cl *cluster.Cluster, network *hcloud.Network, p
 
 	cl.Logger.Debug.Printf("Applying image\n")
 	err = utils.Retry(cl.Logger, func() error {
-		_, err := sshKeyPrivate.Execute(server.PublicNet.IPv4.IP.String(), 22, fmt.Sprintf(`
+		_, err := sshKeyPrivate.Execute(sshIP, 22, fmt.Sprintf(`
 			cd /tmp
 			wget -O /tmp/image.xz %s
 			xz -d -c /tmp/image.xz | dd of=/dev/sda && sync
@@ -321,3 +594,87 @@ func HcloudCreateServerFromImage(cl *cluster.Cluster, network *hcloud.Network, p
 
 	return server, nil
 }
+
+// hcloudCreateServerFromSnapshot creates the server directly from a snapshot
+// with Talos preinstalled, so no public IP is needed to write the image.
+func hcloudCreateServerFromSnapshot(cl *cluster.Cluster, network *hcloud.Network, placementGroup *hcloud.PlacementGroup, tmpl HcloudServerCreateFromImageOpts) (*hcloud.Server, error) {
+	cl.Logger.Info.Printf("Creating new server %q from snapshot %q\n", tmpl.Name, tmpl.ImageSnapshot)
+	image, _, err := cl.Client.Image.Get(*cl.Ctx, tmpl.ImageSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	if image == nil {
+		return nil, fmt.Errorf("image snapshot %q could not be found", tmpl.ImageSnapshot)
+	}
+
+	baseAndFinalizeLabels := map[string]string{}
+	for k, v := range tmpl.BaseLabels {
+		baseAndFinalizeLabels[k] = v
+	}
+	for k, v := range tmpl.FinalizeLabels {
+		baseAndFinalizeLabels[k] = v
+	}
+	startAfterCreate := true
+	serverRespone, _, err := cl.Client.Server.Create(*cl.Ctx, hcloud.ServerCreateOpts{
+		Name: tmpl.Name,
+		ServerType: hcloud.ServerTypeFromSchema(schema.ServerType{
+			Name: tmpl.ServerType,
+		}),
+		Image:          image,
+		PlacementGroup: placementGroup,
+		Location: &hcloud.Location{
+			Name: tmpl.Location,
+		},
+		Networks: []*hcloud.Network{
+			network,
+		},
+		PublicNet: &hcloud.ServerCreatePublicNet{
+			EnableIPv4: tmpl.PublicIPv4,
+			EnableIPv6: tmpl.PublicIPv6,
+		},
+		StartAfterCreate: &startAfterCreate,
+		UserData:         tmpl.UserData,
+		Labels:           baseAndFinalizeLabels,
+	})
+	if err != nil {
+		return nil, hcloudServerCreateError(tmpl, err)
+	}
+	cl.RecordCreated("server", serverRespone.Server.Name, serverRespone.Server.ID)
+
+	return hcloudWaitServerIPs(cl, serverRespone.Server, tmpl)
+}
+
+func hcloudWaitServerIPs(cl *cluster.Cluster, server *hcloud.Server, tmpl HcloudServerCreateFromImageOpts) (*hcloud.Server, error) {
+	cl.Logger.Debug.Printf("Waiting for server to aquire IPs\n")
+	err := utils.Retry(cl.Logger, func() error {
+		var err error
+		server, _, err = cl.Client.Server.GetByID(*cl.Ctx, server.ID)
+		if err != nil {
+			return err
+		}
+		if tmpl.PublicIPv4 && server.PublicNet.IPv4.IP.Equal(net.IP{}) {
+			return fmt.Errorf("server does not yet have a public IPv4")
+		}
+		if tmpl.PublicIPv6 && server.PublicNet.IPv6.Network == nil {
+			return fmt.Errorf("server does not yet have a public IPv6")
+		}
+		if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
+			return fmt.Errorf("server does not yet have a private IP")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cl.Logger.Debug.Printf("Server IPs are %v, %v and %v\n", server.PublicNet.IPv4.IP, server.PublicNet.IPv6.IP, server.PrivateNet[0].IP)
+	return server, nil
+}
+
+// hcloudServerIPv6 returns the first address of the server's IPv6 network,
+// which is the one Hetzner configures on the server.
+func hcloudServerIPv6(server *hcloud.Server) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, server.PublicNet.IPv6.Network.IP.To16())
+	ip[net.IPv6len-1] |= 1
+	return ip
+}
@@ -0,0 +1,503 @@
+package clients
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// hcloudDrift collects the differences between an existing resource and its
+// template. Each difference may come with a function that repairs it.
+type hcloudDrift struct {
+	kind    string
+	name    string
+	entries []hcloudDriftEntry
+	id      int
+}
+
+type hcloudDriftEntry struct {
+	message string
+	repair  func() error
+}
+
+func (d *hcloudDrift) add(repair func() error, format string, args ...interface{}) {
+	d.entries = append(d.entries, hcloudDriftEntry{message: fmt.Sprintf(format, args...), repair: repair})
+}
+
+// resolve reports all differences and repairs them if update is set.
+// Differences that cannot be repaired in place are only reported. It returns
+// the number of differences left. Repaired resources are recorded as updated.
+func (d *hcloudDrift) resolve(cl *cluster.Cluster, update bool) (int, error) {
+	drifts := 0
+	repaired := false
+	for _, entry := range d.entries {
+		if !update || entry.repair == nil {
+			cl.Logger.Warn.Printf("Drift of %s %q: %s\n", d.kind, d.name, entry.message)
+			drifts++
+			continue
+		}
+		cl.Logger.Info.Printf("Repairing drift of %s %q: %s\n", d.kind, d.name, entry.message)
+		if err := entry.repair(); err != nil {
+			return drifts, err
+		}
+		if !repaired {
+			repaired = true
+			cl.RecordUpdated(strings.ReplaceAll(d.kind, " ", "-"), d.name, d.id)
+		}
+	}
+	return drifts, nil
+}
+
+func hcloudReconcileNetwork(cl *cluster.Cluster, network *hcloud.Network, tmpl hcloud.NetworkCreateOpts, update bool) (int, error) {
+	drift := hcloudDrift{kind: "network", name: network.Name, id: network.ID}
+	if tmpl.IPRange != nil && network.IPRange.String() != tmpl.IPRange.String() {
+		drift.add(nil, "ip range is %s instead of %s", network.IPRange, tmpl.IPRange)
+	}
+	for _, subnet := range tmpl.Subnets {
+		subnet := subnet
+		if !hcloudNetworkHasSubnet(network, subnet) {
+			drift.add(func() error {
+				action, _, err := cl.Client.Network.AddSubnet(*cl.Ctx, network, hcloud.NetworkAddSubnetOpts{
+					Subnet: subnet,
+				})
+				if err != nil {
+					return err
+				}
+				return cl.Client.Action.WaitFor(*cl.Ctx, action)
+			}, "subnet %s is missing", subnet.IPRange)
+		}
+	}
+	if !hcloudLabelsContained(tmpl.Labels, network.Labels) {
+		drift.add(func() error {
+			_, _, err := cl.Client.Network.Update(*cl.Ctx, network, hcloud.NetworkUpdateOpts{
+				Labels: hcloudLabelsMerged(network.Labels, tmpl.Labels),
+			})
+			return err
+		}, "labels are %s instead of %s", hcloudLabelsString(network.Labels), hcloudLabelsString(tmpl.Labels))
+	}
+	return drift.resolve(cl, update)
+}
+
+func hcloudNetworkHasSubnet(network *hcloud.Network, subnet hcloud.NetworkSubnet) bool {
+	for _, existingSubnet := range network.Subnets {
+		if existingSubnet.IPRange.String() == subnet.IPRange.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func hcloudReconcilePlacementGroup(cl *cluster.Cluster, placementGroup *hcloud.PlacementGroup, tmpl hcloud.PlacementGroupCreateOpts, update bool) (int, error) {
+	drift := hcloudDrift{kind: "placement group", name: placementGroup.Name, id: placementGroup.ID}
+	if placementGroup.Type != tmpl.Type {
+		drift.add(nil, "type is %s instead of %s", placementGroup.Type, tmpl.Type)
+	}
+	if !hcloudLabelsContained(tmpl.Labels, placementGroup.Labels) {
+		drift.add(func() error {
+			_, _, err := cl.Client.PlacementGroup.Update(*cl.Ctx, placementGroup, hcloud.PlacementGroupUpdateOpts{
+				Labels: hcloudLabelsMerged(placementGroup.Labels, tmpl.Labels),
+			})
+			return err
+		}, "labels are %s instead of %s", hcloudLabelsString(placementGroup.Labels), hcloudLabelsString(tmpl.Labels))
+	}
+	return drift.resolve(cl, update)
+}
+
+func hcloudReconcileLoadBalancer(cl *cluster.Cluster, loadBalancer *hcloud.LoadBalancer, tmpl hcloud.LoadBalancerCreateOpts, update bool) (int, error) {
+	drift := hcloudDrift{kind: "load balancer", name: loadBalancer.Name, id: loadBalancer.ID}
+	if tmpl.LoadBalancerType != nil && loadBalancer.LoadBalancerType.Name != tmpl.LoadBalancerType.Name {
+		drift.add(func() error {
+			action, _, err := cl.Client.LoadBalancer.ChangeType(*cl.Ctx, loadBalancer, hcloud.LoadBalancerChangeTypeOpts{
+				LoadBalancerType: tmpl.LoadBalancerType,
+			})
+			if err != nil {
+				return err
+			}
+			return cl.Client.Action.WaitFor(*cl.Ctx, action)
+		}, "type is %s instead of %s", loadBalancer.LoadBalancerType.Name, tmpl.LoadBalancerType.Name)
+	}
+	if tmpl.Algorithm != nil && loadBalancer.Algorithm.Type != tmpl.Algorithm.Type {
+		drift.add(func() error {
+			action, _, err := cl.Client.LoadBalancer.ChangeAlgorithm(*cl.Ctx, loadBalancer, hcloud.LoadBalancerChangeAlgorithmOpts{
+				Type: tmpl.Algorithm.Type,
+			})
+			if err != nil {
+				return err
+			}
+			return cl.Client.Action.WaitFor(*cl.Ctx, action)
+		}, "algorithm is %s instead of %s", loadBalancer.Algorithm.Type, tmpl.Algorithm.Type)
+	}
+	if tmpl.PublicInterface != nil && loadBalancer.PublicNet.Enabled != *tmpl.PublicInterface {
+		drift.add(func() error {
+			var action *hcloud.Action
+			var err error
+			if *tmpl.PublicInterface {
+				action, _, err = cl.Client.LoadBalancer.EnablePublicInterface(*cl.Ctx, loadBalancer)
+			} else {
+				action, _, err = cl.Client.LoadBalancer.DisablePublicInterface(*cl.Ctx, loadBalancer)
+			}
+			if err != nil {
+				return err
+			}
+			return cl.Client.Action.WaitFor(*cl.Ctx, action)
+		}, "public interface enabled is %t instead of %t", loadBalancer.PublicNet.Enabled, *tmpl.PublicInterface)
+	}
+	if tmpl.Network != nil && !hcloudLoadBalancerInNetwork(loadBalancer, tmpl.Network) {
+		drift.add(func() error {
+			action, _, err := cl.Client.LoadBalancer.AttachToNetwork(*cl.Ctx, loadBalancer, hcloud.LoadBalancerAttachToNetworkOpts{
+				Network: tmpl.Network,
+			})
+			if err != nil {
+				return err
+			}
+			return cl.Client.Action.WaitFor(*cl.Ctx, action)
+		}, "not attached to network %q", tmpl.Network.Name)
+	}
+
+	for _, service := range tmpl.Services {
+		service := service
+		existingService := hcloudLoadBalancerFindService(loadBalancer, *service.ListenPort)
+		if existingService == nil {
+			drift.add(func() error {
+				action, _, err := cl.Client.LoadBalancer.AddService(*cl.Ctx, loadBalancer, hcloudLoadBalancerAddServiceOpts(service))
+				if err != nil {
+					return err
+				}
+				return cl.Client.Action.WaitFor(*cl.Ctx, action)
+			}, "service on port %d is missing", *service.ListenPort)
+			continue
+		}
+		if diff := hcloudLoadBalancerServiceDiff(*existingService, service); diff != "" {
+			drift.add(func() error {
+				action, _, err := cl.Client.LoadBalancer.UpdateService(*cl.Ctx, loadBalancer, *service.ListenPort, hcloudLoadBalancerUpdateServiceOpts(service))
+				if err != nil {
+					return err
+				}
+				return cl.Client.Action.WaitFor(*cl.Ctx, action)
+			}, "service on port %d differs (%s)", *service.ListenPort, diff)
+		}
+	}
+	for _, existingService := range loadBalancer.Services {
+		listenPort := existingService.ListenPort
+		if !hcloudLoadBalancerTemplateHasService(tmpl, listenPort) {
+			drift.add(func() error {
+				action, _, err := cl.Client.LoadBalancer.DeleteService(*cl.Ctx, loadBalancer, listenPort)
+				if err != nil {
+					return err
+				}
+				return cl.Client.Action.WaitFor(*cl.Ctx, action)
+			}, "service on port %d is unexpected", listenPort)
+		}
+	}
+
+	for _, target := range tmpl.Targets {
+		if target.Type != hcloud.LoadBalancerTargetTypeLabelSelector {
+			continue
+		}
+		target := target
+		if !hcloudLoadBalancerHasLabelSelectorTarget(loadBalancer, target.LabelSelector.Selector) {
+			drift.add(func() error {
+				action, _, err := cl.Client.LoadBalancer.AddLabelSelectorTarget(*cl.Ctx, loadBalancer, hcloud.LoadBalancerAddLabelSelectorTargetOpts{
+					Selector:     target.LabelSelector.Selector,
+					UsePrivateIP: target.UsePrivateIP,
+				})
+				if err != nil {
+					return err
+				}
+				return cl.Client.Action.WaitFor(*cl.Ctx, action)
+			}, "target %q is missing", target.LabelSelector.Selector)
+		}
+	}
+	for _, existingTarget := range loadBalancer.Targets {
+		if existingTarget.Type != hcloud.LoadBalancerTargetTypeLabelSelector {
+			continue
+		}
+		selector := existingTarget.LabelSelector.Selector
+		if !hcloudLoadBalancerTemplateHasLabelSelectorTarget(tmpl, selector) {
+			drift.add(func() error {
+				action, _, err := cl.Client.LoadBalancer.RemoveLabelSelectorTarget(*cl.Ctx, loadBalancer, selector)
+				if err != nil {
+					return err
+				}
+				return cl.Client.Action.WaitFor(*cl.Ctx, action)
+			}, "target %q is unexpected", selector)
+		}
+	}
+
+	if !hcloudLabelsContained(tmpl.Labels, loadBalancer.Labels) {
+		drift.add(func() error {
+			_, _, err := cl.Client.LoadBalancer.Update(*cl.Ctx, loadBalancer, hcloud.LoadBalancerUpdateOpts{
+				Labels: hcloudLabelsMerged(loadBalancer.Labels, tmpl.Labels),
+			})
+			return err
+		}, "labels are %s instead of %s", hcloudLabelsString(loadBalancer.Labels), hcloudLabelsString(tmpl.Labels))
+	}
+	return drift.resolve(cl, update)
+}
+
+func hcloudLoadBalancerInNetwork(loadBalancer *hcloud.LoadBalancer, network *hcloud.Network) bool {
+	for _, privateNet := range loadBalancer.PrivateNet {
+		if privateNet.Network != nil && privateNet.Network.ID == network.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func hcloudLoadBalancerFindService(loadBalancer *hcloud.LoadBalancer, listenPort int) *hcloud.LoadBalancerService {
+	for i := range loadBalancer.Services {
+		if loadBalancer.Services[i].ListenPort == listenPort {
+			return &loadBalancer.Services[i]
+		}
+	}
+	return nil
+}
+
+func hcloudLoadBalancerTemplateHasService(tmpl hcloud.LoadBalancerCreateOpts, listenPort int) bool {
+	for _, service := range tmpl.Services {
+		if *service.ListenPort == listenPort {
+			return true
+		}
+	}
+	return false
+}
+
+func hcloudLoadBalancerServiceDiff(existing hcloud.LoadBalancerService, tmpl hcloud.LoadBalancerCreateOptsService) string {
+	diffs := []string{}
+	if existing.Protocol != tmpl.Protocol {
+		diffs = append(diffs, fmt.Sprintf("protocol %s instead of %s", existing.Protocol, tmpl.Protocol))
+	}
+	if tmpl.DestinationPort != nil && existing.DestinationPort != *tmpl.DestinationPort {
+		diffs = append(diffs, fmt.Sprintf("destination port %d instead of %d", existing.DestinationPort, *tmpl.DestinationPort))
+	}
+	if tmpl.Proxyprotocol != nil && existing.Proxyprotocol != *tmpl.Proxyprotocol {
+		diffs = append(diffs, fmt.Sprintf("proxy protocol %v instead of %v", existing.Proxyprotocol, *tmpl.Proxyprotocol))
+	}
+	if healthCheck := tmpl.HealthCheck; healthCheck != nil {
+		if existing.HealthCheck.Protocol != healthCheck.Protocol {
+			diffs = append(diffs, fmt.Sprintf("health check protocol %s instead of %s", existing.HealthCheck.Protocol, healthCheck.Protocol))
+		}
+		if healthCheck.Port != nil && existing.HealthCheck.Port != *healthCheck.Port {
+			diffs = append(diffs, fmt.Sprintf("health check port %d instead of %d", existing.HealthCheck.Port, *healthCheck.Port))
+		}
+		if healthCheck.HTTP != nil && healthCheck.HTTP.Path != nil && (existing.HealthCheck.HTTP == nil || existing.HealthCheck.HTTP.Path != *healthCheck.HTTP.Path) {
+			diffs = append(diffs, fmt.Sprintf("health check path differs from %s", *healthCheck.HTTP.Path))
+		}
+		if healthCheck.HTTP != nil && healthCheck.HTTP.TLS != nil && (existing.HealthCheck.HTTP == nil || existing.HealthCheck.HTTP.TLS != *healthCheck.HTTP.TLS) {
+			diffs = append(diffs, fmt.Sprintf("health check tls differs from %v", *healthCheck.HTTP.TLS))
+		}
+	}
+	return strings.Join(diffs, ", ")
+}
+
+func hcloudLoadBalancerAddServiceOpts(service hcloud.LoadBalancerCreateOptsService) hcloud.LoadBalancerAddServiceOpts {
+	opts := hcloud.LoadBalancerAddServiceOpts{
+		Protocol:        service.Protocol,
+		ListenPort:      service.ListenPort,
+		DestinationPort: service.DestinationPort,
+		Proxyprotocol:   service.Proxyprotocol,
+	}
+	if healthCheck := service.HealthCheck; healthCheck != nil {
+		opts.HealthCheck = &hcloud.LoadBalancerAddServiceOptsHealthCheck{
+			Protocol: healthCheck.Protocol,
+			Port:     healthCheck.Port,
+			Interval: healthCheck.Interval,
+			Timeout:  healthCheck.Timeout,
+			Retries:  healthCheck.Retries,
+		}
+		if healthCheck.HTTP != nil {
+			opts.HealthCheck.HTTP = &hcloud.LoadBalancerAddServiceOptsHealthCheckHTTP{
+				Domain:      healthCheck.HTTP.Domain,
+				Path:        healthCheck.HTTP.Path,
+				Response:    healthCheck.HTTP.Response,
+				StatusCodes: healthCheck.HTTP.StatusCodes,
+				TLS:         healthCheck.HTTP.TLS,
+			}
+		}
+	}
+	return opts
+}
+
+func hcloudLoadBalancerUpdateServiceOpts(service hcloud.LoadBalancerCreateOptsService) hcloud.LoadBalancerUpdateServiceOpts {
+	opts := hcloud.LoadBalancerUpdateServiceOpts{
+		Protocol:        service.Protocol,
+		DestinationPort: service.DestinationPort,
+		Proxyprotocol:   service.Proxyprotocol,
+	}
+	if healthCheck := service.HealthCheck; healthCheck != nil {
+		opts.HealthCheck = &hcloud.LoadBalancerUpdateServiceOptsHealthCheck{
+			Protocol: healthCheck.Protocol,
+			Port:     healthCheck.Port,
+			Interval: healthCheck.Interval,
+			Timeout:  healthCheck.Timeout,
+			Retries:  healthCheck.Retries,
+		}
+		if healthCheck.HTTP != nil {
+			opts.HealthCheck.HTTP = &hcloud.LoadBalancerUpdateServiceOptsHealthCheckHTTP{
+				Domain:      healthCheck.HTTP.Domain,
+				Path:        healthCheck.HTTP.Path,
+				Response:    healthCheck.HTTP.Response,
+				StatusCodes: healthCheck.HTTP.StatusCodes,
+				TLS:         healthCheck.HTTP.TLS,
+			}
+		}
+	}
+	return opts
+}
+
+func hcloudLoadBalancerHasLabelSelectorTarget(loadBalancer *hcloud.LoadBalancer, selector string) bool {
+	for _, target := range loadBalancer.Targets {
+		if target.Type == hcloud.LoadBalancerTargetTypeLabelSelector && target.LabelSelector != nil && target.LabelSelector.Selector == selector {
+			return true
+		}
+	}
+	return false
+}
+
+func hcloudLoadBalancerTemplateHasLabelSelectorTarget(tmpl hcloud.LoadBalancerCreateOpts, selector string) bool {
+	for _, target := range tmpl.Targets {
+		if target.Type == hcloud.LoadBalancerTargetTypeLabelSelector && target.LabelSelector.Selector == selector {
+			return true
+		}
+	}
+	return false
+}
+
+func hcloudReconcileFirewall(cl *cluster.Cluster, firewall *hcloud.Firewall, tmpl hcloud.FirewallCreateOpts, update bool) (int, error) {
+	drift := hcloudDrift{kind: "firewall", name: firewall.Name, id: firewall.ID}
+	if !hcloudFirewallRulesEqual(firewall.Rules, tmpl.Rules) {
+		drift.add(func() error {
+			return HcloudSetFirewallRules(cl, firewall, tmpl.Rules)
+		}, "rules differ")
+	}
+	for _, resource := range tmpl.ApplyTo {
+		resource := resource
+		if resource.Type == hcloud.FirewallResourceTypeLabelSelector && !hcloudFirewallHasLabelSelector(firewall.AppliedTo, resource.LabelSelector.Selector) {
+			drift.add(func() error {
+				actions, _, err := cl.Client.Firewall.ApplyResources(*cl.Ctx, firewall, []hcloud.FirewallResource{resource})
+				if err != nil {
+					return err
+				}
+				return cl.Client.Action.WaitFor(*cl.Ctx, actions...)
+			}, "not applied to %q", resource.LabelSelector.Selector)
+		}
+	}
+	for _, resource := range firewall.AppliedTo {
+		resource := resource
+		if resource.Type == hcloud.FirewallResourceTypeLabelSelector && !hcloudFirewallHasLabelSelector(tmpl.ApplyTo, resource.LabelSelector.Selector) {
+			drift.add(func() error {
+				actions, _, err := cl.Client.Firewall.RemoveResources(*cl.Ctx, firewall, []hcloud.FirewallResource{resource})
+				if err != nil {
+					return err
+				}
+				return cl.Client.Action.WaitFor(*cl.Ctx, actions...)
+			}, "unexpectedly applied to %q", resource.LabelSelector.Selector)
+		}
+	}
+	if !hcloudLabelsContained(tmpl.Labels, firewall.Labels) {
+		drift.add(func() error {
+			_, _, err := cl.Client.Firewall.Update(*cl.Ctx, firewall, hcloud.FirewallUpdateOpts{
+				Labels: hcloudLabelsMerged(firewall.Labels, tmpl.Labels),
+			})
+			return err
+		}, "labels are %s instead of %s", hcloudLabelsString(firewall.Labels), hcloudLabelsString(tmpl.Labels))
+	}
+	return drift.resolve(cl, update)
+}
+
+func hcloudFirewallHasLabelSelector(resources []hcloud.FirewallResource, selector string) bool {
+	for _, resource := range resources {
+		if resource.Type == hcloud.FirewallResourceTypeLabelSelector && resource.LabelSelector != nil && resource.LabelSelector.Selector == selector {
+			return true
+		}
+	}
+	return false
+}
+
+// hcloudFirewallRulesEqual compares rules regardless of their order and of
+// the order of their IPs, as hcloud does not keep them as sent.
+func hcloudFirewallRulesEqual(a []hcloud.FirewallRule, b []hcloud.FirewallRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	aKeys := hcloudFirewallRuleKeys(a)
+	bKeys := hcloudFirewallRuleKeys(b)
+	for i := range aKeys {
+		if aKeys[i] != bKeys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hcloudFirewallRuleKeys returns a sorted list of strings identifying the
+// rules, with their IPs sorted and de-duplicated.
+func hcloudFirewallRuleKeys(rules []hcloud.FirewallRule) []string {
+	ptrString := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	ipNetsString := func(ipNets []net.IPNet) string {
+		strs := []string{}
+		seen := map[string]bool{}
+		for _, ipNet := range ipNets {
+			str := ipNet.String()
+			if !seen[str] {
+				seen[str] = true
+				strs = append(strs, str)
+			}
+		}
+		sort.Strings(strs)
+		return strings.Join(strs, ",")
+	}
+	keys := []string{}
+	for _, rule := range rules {
+		keys = append(keys, strings.Join([]string{
+			string(rule.Direction),
+			string(rule.Protocol),
+			ptrString(rule.Port),
+			ptrString(rule.Description),
+			ipNetsString(rule.SourceIPs),
+			ipNetsString(rule.DestinationIPs),
+		}, "|"))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hcloudLabelsContained reports whether all expected labels are set. Labels
+// added by others are tolerated.
+func hcloudLabelsContained(expected map[string]string, actual map[string]string) bool {
+	for k, v := range expected {
+		if actualValue, ok := actual[k]; !ok || actualValue != v {
+			return false
+		}
+	}
+	return true
+}
+
+func hcloudLabelsMerged(actual map[string]string, expected map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range actual {
+		result[k] = v
+	}
+	for k, v := range expected {
+		result[k] = v
+	}
+	return result
+}
+
+func hcloudLabelsString(labels map[string]string) string {
+	strs := []string{}
+	for k, v := range labels {
+		strs = append(strs, k+"="+v)
+	}
+	sort.Strings(strs)
+	return "{" + strings.Join(strs, ",") + "}"
+}
@@ -0,0 +1,219 @@
+package clients
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []net.IPNet {
+	result := []net.IPNet{}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("%q is invalid: %v", cidr, err)
+		}
+		result = append(result, *ipNet)
+	}
+	return result
+}
+
+func TestHcloudFirewallRulesEqual(t *testing.T) {
+	port := "6443"
+	otherPort := "50000"
+	description := "kubernetes"
+	rule := func(port *string, sourceIPs ...string) hcloud.FirewallRule {
+		return hcloud.FirewallRule{
+			Direction: hcloud.FirewallRuleDirectionIn,
+			Protocol:  hcloud.FirewallRuleProtocolTCP,
+			Port:      port,
+			SourceIPs: mustParseCIDRs(t, sourceIPs...),
+		}
+	}
+	tests := []struct {
+		name     string
+		a        []hcloud.FirewallRule
+		b        []hcloud.FirewallRule
+		expected bool
+	}{
+		{
+			name:     "empty",
+			a:        []hcloud.FirewallRule{},
+			b:        nil,
+			expected: true,
+		},
+		{
+			name:     "equal",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16")},
+			b:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16")},
+			expected: true,
+		},
+		{
+			name:     "rules in different order",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16"), rule(&otherPort, "0.0.0.0/0")},
+			b:        []hcloud.FirewallRule{rule(&otherPort, "0.0.0.0/0"), rule(&port, "10.0.0.0/16")},
+			expected: true,
+		},
+		{
+			name:     "source IPs in different order",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16", "0.0.0.0/0", "::/0")},
+			b:        []hcloud.FirewallRule{rule(&port, "::/0", "10.0.0.0/16", "0.0.0.0/0")},
+			expected: true,
+		},
+		{
+			name:     "duplicate source IPs",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16", "10.0.0.0/16")},
+			b:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16")},
+			expected: true,
+		},
+		{
+			name:     "different source IPs",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16")},
+			b:        []hcloud.FirewallRule{rule(&port, "10.1.0.0/16")},
+			expected: false,
+		},
+		{
+			name:     "different ports",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16")},
+			b:        []hcloud.FirewallRule{rule(&otherPort, "10.0.0.0/16")},
+			expected: false,
+		},
+		{
+			name:     "missing port",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16")},
+			b:        []hcloud.FirewallRule{rule(nil, "10.0.0.0/16")},
+			expected: false,
+		},
+		{
+			name: "different descriptions",
+			a:    []hcloud.FirewallRule{rule(&port, "10.0.0.0/16")},
+			b: []hcloud.FirewallRule{func() hcloud.FirewallRule {
+				r := rule(&port, "10.0.0.0/16")
+				r.Description = &description
+				return r
+			}()},
+			expected: false,
+		},
+		{
+			name:     "same rule twice against two different rules",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16"), rule(&port, "10.0.0.0/16")},
+			b:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16"), rule(&otherPort, "10.0.0.0/16")},
+			expected: false,
+		},
+		{
+			name:     "additional rule",
+			a:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16")},
+			b:        []hcloud.FirewallRule{rule(&port, "10.0.0.0/16"), rule(&otherPort, "10.0.0.0/16")},
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		if actual := hcloudFirewallRulesEqual(test.a, test.b); actual != test.expected {
+			t.Errorf("%s: hcloudFirewallRulesEqual = %v, expected %v", test.name, actual, test.expected)
+		}
+		if actual := hcloudFirewallRulesEqual(test.b, test.a); actual != test.expected {
+			t.Errorf("%s: hcloudFirewallRulesEqual reversed = %v, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestHcloudLoadBalancerServiceDiff(t *testing.T) {
+	listenPort := 6443
+	destinationPort := 6443
+	otherPort := 50000
+	proxyprotocol := true
+	path := "/healthz"
+	tls := true
+	existing := func() hcloud.LoadBalancerService {
+		return hcloud.LoadBalancerService{
+			Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
+			ListenPort:      listenPort,
+			DestinationPort: destinationPort,
+			HealthCheck: hcloud.LoadBalancerServiceHealthCheck{
+				Protocol: hcloud.LoadBalancerServiceProtocolTCP,
+				Port:     destinationPort,
+			},
+		}
+	}
+	tmpl := func() hcloud.LoadBalancerCreateOptsService {
+		return hcloud.LoadBalancerCreateOptsService{
+			Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
+			ListenPort:      &listenPort,
+			DestinationPort: &destinationPort,
+			HealthCheck: &hcloud.LoadBalancerCreateOptsServiceHealthCheck{
+				Protocol: hcloud.LoadBalancerServiceProtocolTCP,
+				Port:     &destinationPort,
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		existing func(s *hcloud.LoadBalancerService)
+		tmpl     func(s *hcloud.LoadBalancerCreateOptsService)
+		expected string
+	}{
+		{
+			name:     "equal",
+			expected: "",
+		},
+		{
+			name:     "template without optional fields",
+			tmpl:     func(s *hcloud.LoadBalancerCreateOptsService) { s.DestinationPort = nil; s.HealthCheck = nil },
+			expected: "",
+		},
+		{
+			name:     "protocol",
+			tmpl:     func(s *hcloud.LoadBalancerCreateOptsService) { s.Protocol = hcloud.LoadBalancerServiceProtocolHTTP },
+			expected: "protocol tcp instead of http",
+		},
+		{
+			name:     "destination port",
+			tmpl:     func(s *hcloud.LoadBalancerCreateOptsService) { s.DestinationPort = &otherPort },
+			expected: "destination port 6443 instead of 50000",
+		},
+		{
+			name:     "proxy protocol",
+			tmpl:     func(s *hcloud.LoadBalancerCreateOptsService) { s.Proxyprotocol = &proxyprotocol },
+			expected: "proxy protocol false instead of true",
+		},
+		{
+			name: "health check",
+			tmpl: func(s *hcloud.LoadBalancerCreateOptsService) {
+				s.HealthCheck.Protocol = hcloud.LoadBalancerServiceProtocolHTTP
+				s.HealthCheck.Port = &otherPort
+			},
+			expected: "health check protocol tcp instead of http, health check port 6443 instead of 50000",
+		},
+		{
+			name: "health check http without existing http",
+			tmpl: func(s *hcloud.LoadBalancerCreateOptsService) {
+				s.HealthCheck.HTTP = &hcloud.LoadBalancerCreateOptsServiceHealthCheckHTTP{Path: &path, TLS: &tls}
+			},
+			expected: "health check path differs from /healthz, health check tls differs from true",
+		},
+		{
+			name: "health check http equal",
+			existing: func(s *hcloud.LoadBalancerService) {
+				s.HealthCheck.HTTP = &hcloud.LoadBalancerServiceHealthCheckHTTP{Path: path, TLS: tls}
+			},
+			tmpl: func(s *hcloud.LoadBalancerCreateOptsService) {
+				s.HealthCheck.HTTP = &hcloud.LoadBalancerCreateOptsServiceHealthCheckHTTP{Path: &path, TLS: &tls}
+			},
+			expected: "",
+		},
+	}
+	for _, test := range tests {
+		e := existing()
+		if test.existing != nil {
+			test.existing(&e)
+		}
+		s := tmpl()
+		if test.tmpl != nil {
+			test.tmpl(&s)
+		}
+		if actual := hcloudLoadBalancerServiceDiff(e, s); actual != test.expected {
+			t.Errorf("%s: hcloudLoadBalancerServiceDiff = %q, expected %q", test.name, actual, test.expected)
+		}
+	}
+}
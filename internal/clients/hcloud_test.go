@@ -0,0 +1,37 @@
+package clients
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+func TestHcloudServerCreateError(t *testing.T) {
+	tmpl := HcloudServerCreateFromImageOpts{ServerType: "cx22", Location: "nbg1"}
+	tests := []struct {
+		name     string
+		err      error
+		capacity bool
+	}{
+		{name: "resource unavailable", err: hcloud.Error{Code: hcloud.ErrorCodeResourceUnavailable}, capacity: true},
+		{name: "placement error", err: hcloud.Error{Code: hcloud.ErrorCodePlacementError}, capacity: true},
+		{name: "unsupported location", err: hcloud.Error{Code: hcloudErrorCodeUnsupportedLocationForServerType}, capacity: true},
+		{name: "invalid input", err: hcloud.Error{Code: hcloud.ErrorCodeInvalidInput}},
+		{name: "other error", err: errors.New("connection refused")},
+	}
+	for _, test := range tests {
+		err := hcloudServerCreateError(tmpl, test.err)
+		var capacityErr *HcloudCapacityError
+		if errors.As(err, &capacityErr) != test.capacity {
+			t.Errorf("%s: capacity error = %v, expected %v", test.name, !test.capacity, test.capacity)
+			continue
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: %v does not wrap %v", test.name, err, test.err)
+		}
+		if test.capacity && (capacityErr.ServerType != "cx22" || capacityErr.Location != "nbg1") {
+			t.Errorf("%s: capacity error is for %s in %s", test.name, capacityErr.ServerType, capacityErr.Location)
+		}
+	}
+}
@@ -38,6 +38,63 @@ func KubernetesWaitNodeRegistered(cl *cluster.Cluster, name string) error {
 	})
 }
 
+func KubernetesWaitNodeReady(cl *cluster.Cluster, name string) error {
+	clientset, _, err := KubernetesInit(cl)
+	if err != nil {
+		return err
+	}
+	return utils.RetrySlow(cl.Logger, func() error {
+		node, err := clientset.CoreV1().Nodes().Get(*cl.Ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady && condition.Status == v1.ConditionTrue {
+				return nil
+			}
+		}
+		return fmt.Errorf("node not yet ready")
+	})
+}
+
+// KubernetesNodeBootID returns the boot ID of the node, which changes with
+// every reboot.
+func KubernetesNodeBootID(cl *cluster.Cluster, name string) (string, error) {
+	clientset, _, err := KubernetesInit(cl)
+	if err != nil {
+		return "", err
+	}
+	node, err := clientset.CoreV1().Nodes().Get(*cl.Ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return node.Status.NodeInfo.BootID, nil
+}
+
+// KubernetesWaitNodeRebooted waits until the node has reported a boot ID
+// other than the given one or has become not ready.
+func KubernetesWaitNodeRebooted(cl *cluster.Cluster, name string, bootID string) error {
+	clientset, _, err := KubernetesInit(cl)
+	if err != nil {
+		return err
+	}
+	return utils.RetrySlow(cl.Logger, func() error {
+		node, err := clientset.CoreV1().Nodes().Get(*cl.Ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Status.NodeInfo.BootID != bootID {
+			return nil
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady && condition.Status != v1.ConditionTrue {
+				return nil
+			}
+		}
+		return fmt.Errorf("node not yet rebooted")
+	})
+}
+
 func KubernetesWaitPodRunning(cl *cluster.Cluster, namespace string, name string) error {
 	clientset, _, err := KubernetesInit(cl)
 	if err != nil {
@@ -79,6 +136,18 @@ func KubernetesCreateFromManifest(cl *cluster.Cluster, manifest string) error {
 	return nil
 }
 
+func KubernetesListNodes(cl *cluster.Cluster) ([]v1.Node, error) {
+	clientset, _, err := KubernetesInit(cl)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(*cl.Ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
 func KubernetesDeleteNode(cl *cluster.Cluster, name string) error {
 	clientset, _, err := KubernetesInit(cl)
 	if err != nil {
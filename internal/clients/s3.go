@@ -0,0 +1,22 @@
+package clients
+
+import (
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Opts struct {
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Insecure        bool
+}
+
+func S3Init(opts S3Opts) (*minio.Client, error) {
+	return minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, ""),
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+}
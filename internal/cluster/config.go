@@ -1,12 +1,444 @@
 package cluster
 
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	DefaultNetworkIPRange    = "10.0.0.0/16"
+	DefaultNetworkNodeSubnet = "10.0.0.0/24"
+	DefaultPodSubnet         = "10.244.0.0/16"
+	DefaultServiceSubnet     = "10.96.0.0/12"
+	DefaultPodSubnet6        = "fd00:10:244::/56"
+	DefaultServiceSubnet6    = "fd00:10:96::/112"
+)
+
 type Config struct {
-	ClusterName string       `yaml:"clusterName"`
-	Hcloud      ConfigHcloud `yaml:"hcloud"`
+	ClusterName  string         `yaml:"clusterName"`
+	Hcloud       ConfigHcloud   `yaml:"hcloud"`
+	Network      ConfigNetwork  `yaml:"network,omitempty"`
+	Cni          ConfigCni      `yaml:"cni,omitempty"`
+	Controlplane ConfigPool     `yaml:"controlplane,omitempty"`
+	Pools        []ConfigPool   `yaml:"pools,omitempty"`
+	Firewall     ConfigFirewall `yaml:"firewall,omitempty"`
+
+	ControlplaneEndpoint     string             `yaml:"controlplaneEndpoint,omitempty"`
+	ControlplaneLoadBalancer ConfigLoadBalancer `yaml:"controlplaneLoadBalancer,omitempty"`
+	Bastion                  ConfigBastion      `yaml:"bastion,omitempty"`
+	Ingress                  ConfigIngress      `yaml:"ingress,omitempty"`
+}
+
+// IsFloatingIPEndpoint reports whether the controlplane is reached through a
+// floating IP held by one of the controlplane nodes instead of through a load
+// balancer.
+func (c Config) IsFloatingIPEndpoint() bool {
+	return c.ControlplaneEndpoint == "floating-ip"
+}
+
+// IsPrivateEndpoint reports whether the controlplane load balancer has no
+// public interface, so that the Kubernetes and Talos API are only reachable
+// from the private network or through the WireGuard bastion.
+func (c Config) IsPrivateEndpoint() bool {
+	return c.ControlplaneEndpoint == "private-load-balancer"
 }
 
 type ConfigHcloud struct {
 	Location    string `yaml:"location"`
 	NetworkZone string `yaml:"networkZone"`
 	Token       string `yaml:"token"`
+	// ServerLimit is the server limit of the project, which hcloud does
+	// not expose. Preflight checks skip the limit if not set.
+	ServerLimit int `yaml:"serverLimit,omitempty"`
+}
+
+type ConfigNetwork struct {
+	ExistingNetwork string   `yaml:"existingNetwork,omitempty"`
+	IPRange         string   `yaml:"ipRange,omitempty"`
+	NodeSubnets     []string `yaml:"nodeSubnets,omitempty"`
+	PodRouting      string   `yaml:"podRouting,omitempty"`
+	PodSubnet       string   `yaml:"podSubnet,omitempty"`
+	ServiceSubnet   string   `yaml:"serviceSubnet,omitempty"`
+	DualStack       bool     `yaml:"dualStack,omitempty"`
+	PodSubnet6      string   `yaml:"podSubnet6,omitempty"`
+	ServiceSubnet6  string   `yaml:"serviceSubnet6,omitempty"`
+	NatGateway      bool     `yaml:"natGateway,omitempty"`
+	NatGatewayType  string   `yaml:"natGatewayType,omitempty"`
+}
+
+// Gateway returns the address of the hcloud network gateway, which is always
+// the first address of the network IP range.
+func (c ConfigNetwork) Gateway() net.IP {
+	_, ipRange, err := net.ParseCIDR(c.IPRange)
+	if err != nil || ipRange.IP.To4() == nil {
+		return nil
+	}
+	gateway := make(net.IP, net.IPv4len)
+	copy(gateway, ipRange.IP.To4())
+	gateway[net.IPv4len-1] |= 1
+	return gateway
+}
+
+// SetDefaults fills in the network layout left empty. Native pod routing
+// takes the upper half of the network IP range for pods, which the default
+// node subnet leaves free, as hcloud only routes within the network.
+func (c *ConfigNetwork) SetDefaults() {
+	if c.IPRange == "" {
+		c.IPRange = DefaultNetworkIPRange
+	}
+	if len(c.NodeSubnets) == 0 {
+		c.NodeSubnets = []string{DefaultNetworkNodeSubnet}
+	}
+	if c.PodSubnet == "" {
+		c.PodSubnet = DefaultPodSubnet
+		if c.IsNativePodRouting() {
+			c.PodSubnet = upperHalf(c.IPRange)
+		}
+	}
+	if c.ServiceSubnet == "" {
+		c.ServiceSubnet = DefaultServiceSubnet
+	}
+	if c.DualStack && c.PodSubnet6 == "" {
+		c.PodSubnet6 = DefaultPodSubnet6
+	}
+	if c.DualStack && c.ServiceSubnet6 == "" {
+		c.ServiceSubnet6 = DefaultServiceSubnet6
+	}
+}
+
+// upperHalf returns the upper half of an IPv4 range, or an empty string if
+// the range can not be split.
+func upperHalf(cidr string) string {
+	_, ipRange, err := net.ParseCIDR(cidr)
+	if err != nil || ipRange.IP.To4() == nil {
+		return ""
+	}
+	ones, bits := ipRange.Mask.Size()
+	if ones >= bits {
+		return ""
+	}
+	ip := make(net.IP, net.IPv4len)
+	copy(ip, ipRange.IP.To4())
+	ip[ones/8] |= 0x80 >> (ones % 8)
+	return (&net.IPNet{IP: ip, Mask: net.CIDRMask(ones+1, bits)}).String()
+}
+
+// IsNativePodRouting reports whether pod traffic is routed through hcloud
+// network routes instead of being encapsulated by the CNI.
+func (c ConfigNetwork) IsNativePodRouting() bool {
+	return c.PodRouting == "native"
+}
+
+// PodSubnets returns the pod subnets, including the IPv6 one for dual-stack
+// clusters.
+func (c ConfigNetwork) PodSubnets() []string {
+	if c.DualStack {
+		return []string{c.PodSubnet, c.PodSubnet6}
+	}
+	return []string{c.PodSubnet}
+}
+
+// ServiceSubnets returns the service subnets, including the IPv6 one for
+// dual-stack clusters.
+func (c ConfigNetwork) ServiceSubnets() []string {
+	if c.DualStack {
+		return []string{c.ServiceSubnet, c.ServiceSubnet6}
+	}
+	return []string{c.ServiceSubnet}
+}
+
+// Validate ensures that node subnets are part of the network IP range and that
+// node, pod and service subnets do not overlap each other.
+func (c ConfigNetwork) Validate() error {
+	_, ipRange, err := net.ParseCIDR(c.IPRange)
+	if err != nil {
+		return fmt.Errorf("network ip range is invalid: %w", err)
+	}
+	if len(c.NodeSubnets) == 0 {
+		return fmt.Errorf("network node subnets must not be empty")
+	}
+	nodeSubnets := []*net.IPNet{}
+	for _, nodeSubnetStr := range c.NodeSubnets {
+		_, nodeSubnet, err := net.ParseCIDR(nodeSubnetStr)
+		if err != nil {
+			return fmt.Errorf("network node subnet is invalid: %w", err)
+		}
+		if !cidrContains(ipRange, nodeSubnet) {
+			return fmt.Errorf("network node subnet %s is not part of network ip range %s", nodeSubnet, ipRange)
+		}
+		for _, otherNodeSubnet := range nodeSubnets {
+			if cidrsOverlap(nodeSubnet, otherNodeSubnet) {
+				return fmt.Errorf("network node subnets %s and %s overlap", nodeSubnet, otherNodeSubnet)
+			}
+		}
+		nodeSubnets = append(nodeSubnets, nodeSubnet)
+	}
+	_, podSubnet, err := net.ParseCIDR(c.PodSubnet)
+	if err != nil {
+		return fmt.Errorf("pod subnet is invalid: %w", err)
+	}
+	_, serviceSubnet, err := net.ParseCIDR(c.ServiceSubnet)
+	if err != nil {
+		return fmt.Errorf("service subnet is invalid: %w", err)
+	}
+	for _, nodeSubnet := range nodeSubnets {
+		if cidrsOverlap(podSubnet, nodeSubnet) {
+			return fmt.Errorf("pod subnet %s overlaps network node subnet %s", podSubnet, nodeSubnet)
+		}
+		if cidrsOverlap(serviceSubnet, nodeSubnet) {
+			return fmt.Errorf("service subnet %s overlaps network node subnet %s", serviceSubnet, nodeSubnet)
+		}
+	}
+	if cidrsOverlap(podSubnet, serviceSubnet) {
+		return fmt.Errorf("pod subnet %s overlaps service subnet %s", podSubnet, serviceSubnet)
+	}
+	if c.IsNativePodRouting() && !cidrContains(ipRange, podSubnet) {
+		return fmt.Errorf("pod subnet %s must be part of network ip range %s for native pod routing", podSubnet, ipRange)
+	}
+	if !c.IsNativePodRouting() && cidrsOverlap(podSubnet, ipRange) {
+		return fmt.Errorf("pod subnet %s must not overlap network ip range %s", podSubnet, ipRange)
+	}
+	if c.DualStack {
+		_, podSubnet6, err := net.ParseCIDR(c.PodSubnet6)
+		if err != nil || podSubnet6.IP.To4() != nil {
+			return fmt.Errorf("ipv6 pod subnet %q is invalid", c.PodSubnet6)
+		}
+		_, serviceSubnet6, err := net.ParseCIDR(c.ServiceSubnet6)
+		if err != nil || serviceSubnet6.IP.To4() != nil {
+			return fmt.Errorf("ipv6 service subnet %q is invalid", c.ServiceSubnet6)
+		}
+		if cidrsOverlap(podSubnet6, serviceSubnet6) {
+			return fmt.Errorf("ipv6 pod subnet %s overlaps ipv6 service subnet %s", podSubnet6, serviceSubnet6)
+		}
+	}
+	return nil
+}
+
+func cidrContains(outer *net.IPNet, inner *net.IPNet) bool {
+	outerSize, _ := outer.Mask.Size()
+	innerSize, _ := inner.Mask.Size()
+	return outer.Contains(inner.IP) && outerSize <= innerSize
+}
+
+func cidrsOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+type ConfigCni struct {
+	Name                 string `yaml:"name,omitempty"`
+	KubeProxyReplacement bool   `yaml:"kubeProxyReplacement,omitempty"`
+}
+
+// IsCilium reports whether cilium is installed instead of the flannel CNI
+// that Talos deploys by default.
+func (c ConfigCni) IsCilium() bool {
+	return c.Name == "cilium"
+}
+
+type ConfigLoadBalancer struct {
+	Type        string `yaml:"type,omitempty"`
+	Algorithm   string `yaml:"algorithm,omitempty"`
+	HealthCheck string `yaml:"healthCheck,omitempty"`
+}
+
+// Validate ensures that algorithm and health check are known. The type is
+// validated by hcloud.
+func (c ConfigLoadBalancer) Validate() error {
+	if c.Algorithm != "" && c.Algorithm != "round_robin" && c.Algorithm != "least_connections" {
+		return fmt.Errorf("load balancer algorithm must be one of round_robin or least_connections")
+	}
+	if c.HealthCheck != "" && c.HealthCheck != "tcp" && c.HealthCheck != "https" {
+		return fmt.Errorf("load balancer health check must be one of tcp or https")
+	}
+	return nil
+}
+
+// ConfigBastion is the WireGuard server giving access to the private network.
+// The keys are generated on bootstrap, the client configuration is written to
+// wireguard.conf.
+type ConfigBastion struct {
+	ServerType       string `yaml:"serverType,omitempty"`
+	Port             int    `yaml:"port,omitempty"`
+	Subnet           string `yaml:"subnet,omitempty"`
+	PrivateKey       string `yaml:"privateKey,omitempty"`
+	ClientPrivateKey string `yaml:"clientPrivateKey,omitempty"`
+}
+
+// Validate ensures that the WireGuard subnet is an IPv4 range that leaves
+// room for the bastion and the client and does not overlap the network.
+func (c ConfigBastion) Validate(network ConfigNetwork) error {
+	_, subnet, err := net.ParseCIDR(c.Subnet)
+	if err != nil || subnet.IP.To4() == nil {
+		return fmt.Errorf("bastion subnet %q must be an IPv4 range", c.Subnet)
+	}
+	if ones, _ := subnet.Mask.Size(); ones > 30 {
+		return fmt.Errorf("bastion subnet %q must be at least a /30", c.Subnet)
+	}
+	_, ipRange, err := net.ParseCIDR(network.IPRange)
+	if err == nil && (ipRange.Contains(subnet.IP) || subnet.Contains(ipRange.IP)) {
+		return fmt.Errorf("bastion subnet %q must not overlap the network IP range %q", c.Subnet, network.IPRange)
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("bastion port %d is invalid", c.Port)
+	}
+	return nil
+}
+
+// Addresses returns the WireGuard addresses of the bastion and the client,
+// which are the first two hosts of the subnet.
+func (c ConfigBastion) Addresses() (net.IP, net.IP) {
+	_, subnet, err := net.ParseCIDR(c.Subnet)
+	if err != nil || subnet.IP.To4() == nil {
+		return nil, nil
+	}
+	bastion := make(net.IP, net.IPv4len)
+	copy(bastion, subnet.IP.To4())
+	bastion[net.IPv4len-1] += 1
+	client := make(net.IP, net.IPv4len)
+	copy(client, subnet.IP.To4())
+	client[net.IPv4len-1] += 2
+	return bastion, client
+}
+
+// ConfigIngress is the load balancer in front of the ingress controller, which
+// runs on all worker nodes or only on those of the given pool.
+type ConfigIngress struct {
+	Enabled          bool   `yaml:"enabled,omitempty"`
+	Pool             string `yaml:"pool,omitempty"`
+	LoadBalancerType string `yaml:"loadBalancerType,omitempty"`
+	ProxyProtocol    bool   `yaml:"proxyProtocol,omitempty"`
+}
+
+// Validate ensures that the ingress pool is declared, as the ingress load
+// balancer and ingress-nginx would otherwise silently target no node.
+func (c ConfigIngress) Validate(pools []ConfigPool) error {
+	if !c.Enabled || c.Pool == "" {
+		return nil
+	}
+	for _, pool := range pools {
+		if pool.Name == c.Pool {
+			return nil
+		}
+	}
+	return fmt.Errorf("ingress pool %q is not declared in pools", c.Pool)
+}
+
+type ConfigFirewall struct {
+	Rules []ConfigFirewallRule `yaml:"rules,omitempty"`
+}
+
+// ConfigFirewallRule is an additional inbound rule. Rules without role and
+// pool apply to all nodes, otherwise only to the nodes of the given role
+// (controlplane or worker) or worker pool.
+type ConfigFirewallRule struct {
+	Description string   `yaml:"description,omitempty"`
+	Protocol    string   `yaml:"protocol"`
+	Port        string   `yaml:"port,omitempty"`
+	SourceIPs   []string `yaml:"sourceIPs"`
+	Role        string   `yaml:"role,omitempty"`
+	Pool        string   `yaml:"pool,omitempty"`
+}
+
+// Validate ensures that the rule can be turned into an hcloud firewall rule.
+func (r ConfigFirewallRule) Validate() error {
+	switch r.Protocol {
+	case "tcp", "udp":
+		if r.Port == "" {
+			return fmt.Errorf("firewall rule for protocol %s requires a port", r.Protocol)
+		}
+	case "icmp", "esp", "gre":
+		if r.Port != "" {
+			return fmt.Errorf("firewall rule for protocol %s must not have a port", r.Protocol)
+		}
+	default:
+		return fmt.Errorf("firewall rule protocol must be one of tcp, udp, icmp, esp or gre")
+	}
+	if len(r.SourceIPs) == 0 {
+		return fmt.Errorf("firewall rule source IPs must not be empty")
+	}
+	for _, sourceIP := range r.SourceIPs {
+		if _, _, err := net.ParseCIDR(sourceIP); err != nil {
+			return fmt.Errorf("firewall rule source IP is invalid: %w", err)
+		}
+	}
+	if r.Role != "" && r.Role != "controlplane" && r.Role != "worker" {
+		return fmt.Errorf("firewall rule role must be one of controlplane or worker")
+	}
+	if r.Pool != "" && r.Role == "controlplane" {
+		return fmt.Errorf("firewall rule pool can only be used with worker role")
+	}
+	return nil
+}
+
+type ConfigPool struct {
+	Name          string            `yaml:"name,omitempty"`
+	Labels        map[string]string `yaml:"labels,omitempty"`
+	Taints        []ConfigTaint     `yaml:"taints,omitempty"`
+	PublicNet     string            `yaml:"publicNet,omitempty"`
+	ImageSnapshot string            `yaml:"imageSnapshot,omitempty"`
+	Locations     []string          `yaml:"locations,omitempty"`
+	ServerTypes   []string          `yaml:"serverTypes,omitempty"`
+}
+
+// HasPublicIPv4 reports whether servers of the pool get a public IPv4. The
+// public network is one of dual (default), ipv6 or none.
+func (p ConfigPool) HasPublicIPv4() bool {
+	return p.PublicNet == "" || p.PublicNet == "dual"
+}
+
+// HasPublicIPv6 reports whether servers of the pool get a public IPv6.
+func (p ConfigPool) HasPublicIPv6() bool {
+	return p.PublicNet == "" || p.PublicNet == "dual" || p.PublicNet == "ipv6"
+}
+
+// Validate ensures that servers of the pool can be provisioned.
+func (p ConfigPool) Validate() error {
+	if p.PublicNet != "" && p.PublicNet != "dual" && p.PublicNet != "ipv6" && p.PublicNet != "none" {
+		return fmt.Errorf("public net of pool %q must be one of dual, ipv6 or none", p.Name)
+	}
+	if p.PublicNet == "none" && p.ImageSnapshot == "" {
+		return fmt.Errorf("pool %q without public IP requires an image snapshot", p.Name)
+	}
+	// Talos keeps the taints of a node in a map by key, so each key can only
+	// be used once
+	taintKeys := map[string]bool{}
+	for _, taint := range p.Taints {
+		if taint.Key == "" {
+			return fmt.Errorf("taint of pool %q requires a key", p.Name)
+		}
+		if taint.Effect != "NoSchedule" && taint.Effect != "PreferNoSchedule" && taint.Effect != "NoExecute" {
+			return fmt.Errorf("taint %q of pool %q must have effect NoSchedule, PreferNoSchedule or NoExecute", taint.Key, p.Name)
+		}
+		if taintKeys[taint.Key] {
+			return fmt.Errorf("taint %q of pool %q is used more than once", taint.Key, p.Name)
+		}
+		taintKeys[taint.Key] = true
+	}
+	return nil
+}
+
+type ConfigTaint struct {
+	Key    string `yaml:"key"`
+	Value  string `yaml:"value,omitempty"`
+	Effect string `yaml:"effect"`
+}
+
+// PoolLocations returns the locations the nodes of the pool are spread
+// across, which default to the cluster location.
+func (c Config) PoolLocations(pool ConfigPool) []string {
+	if len(pool.Locations) > 0 {
+		return pool.Locations
+	}
+	return []string{c.Hcloud.Location}
+}
+
+// FindPool returns the declared worker pool with the given name or an
+// empty pool if it has not been declared in the config.
+func (c Config) FindPool(name string) ConfigPool {
+	for _, pool := range c.Pools {
+		if pool.Name == name {
+			return pool
+		}
+	}
+	return ConfigPool{Name: name}
 }
@@ -0,0 +1,250 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("%q is invalid: %v", cidr, err)
+	}
+	return ipNet
+}
+
+func TestCidrContains(t *testing.T) {
+	tests := []struct {
+		outer    string
+		inner    string
+		expected bool
+	}{
+		{outer: "10.0.0.0/16", inner: "10.0.0.0/24", expected: true},
+		{outer: "10.0.0.0/16", inner: "10.0.255.0/24", expected: true},
+		{outer: "10.0.0.0/16", inner: "10.0.0.0/16", expected: true},
+		{outer: "10.0.0.0/16", inner: "10.0.0.0/8", expected: false},
+		{outer: "10.0.0.0/16", inner: "10.1.0.0/24", expected: false},
+		{outer: "10.0.0.0/24", inner: "10.0.0.0/16", expected: false},
+	}
+	for _, test := range tests {
+		actual := cidrContains(mustParseCIDR(t, test.outer), mustParseCIDR(t, test.inner))
+		if actual != test.expected {
+			t.Errorf("cidrContains(%s, %s) = %v, expected %v", test.outer, test.inner, actual, test.expected)
+		}
+	}
+}
+
+func TestCidrsOverlap(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{a: "10.0.0.0/16", b: "10.0.1.0/24", expected: true},
+		{a: "10.0.1.0/24", b: "10.0.0.0/16", expected: true},
+		{a: "10.0.0.0/24", b: "10.0.0.0/24", expected: true},
+		{a: "10.0.0.0/24", b: "10.0.1.0/24", expected: false},
+		{a: "10.244.0.0/16", b: "10.96.0.0/12", expected: false},
+		{a: "10.0.0.0/8", b: "10.96.0.0/12", expected: true},
+	}
+	for _, test := range tests {
+		actual := cidrsOverlap(mustParseCIDR(t, test.a), mustParseCIDR(t, test.b))
+		if actual != test.expected {
+			t.Errorf("cidrsOverlap(%s, %s) = %v, expected %v", test.a, test.b, actual, test.expected)
+		}
+	}
+}
+
+func TestConfigNetworkGateway(t *testing.T) {
+	tests := []struct {
+		ipRange  string
+		expected string
+	}{
+		{ipRange: "10.0.0.0/16", expected: "10.0.0.1"},
+		{ipRange: "172.16.0.0/12", expected: "172.16.0.1"},
+		{ipRange: "192.168.4.0/22", expected: "192.168.4.1"},
+		{ipRange: "fd00::/64", expected: "<nil>"},
+		{ipRange: "", expected: "<nil>"},
+	}
+	for _, test := range tests {
+		actual := ConfigNetwork{IPRange: test.ipRange}.Gateway().String()
+		if actual != test.expected {
+			t.Errorf("Gateway() of %q = %s, expected %s", test.ipRange, actual, test.expected)
+		}
+	}
+}
+
+func TestConfigNetworkSetDefaults(t *testing.T) {
+	network := ConfigNetwork{}
+	network.SetDefaults()
+	if network.IPRange != DefaultNetworkIPRange || network.PodSubnet != DefaultPodSubnet || network.ServiceSubnet != DefaultServiceSubnet {
+		t.Errorf("unexpected defaults %+v", network)
+	}
+	if network.PodSubnet6 != "" || network.ServiceSubnet6 != "" {
+		t.Errorf("unexpected ipv6 defaults without dual-stack %+v", network)
+	}
+	if err := network.Validate(); err != nil {
+		t.Errorf("defaults are invalid: %v", err)
+	}
+
+	native := ConfigNetwork{IPRange: "172.16.0.0/12", NodeSubnets: []string{"172.16.0.0/24"}, PodRouting: "native"}
+	native.SetDefaults()
+	if native.PodSubnet != "172.24.0.0/13" {
+		t.Errorf("native pod subnet = %s, expected 172.24.0.0/13", native.PodSubnet)
+	}
+	if err := native.Validate(); err != nil {
+		t.Errorf("native defaults are invalid: %v", err)
+	}
+
+	configured := ConfigNetwork{PodSubnet: "10.200.0.0/16", DualStack: true}
+	configured.SetDefaults()
+	if configured.PodSubnet != "10.200.0.0/16" {
+		t.Errorf("configured pod subnet was overwritten with %s", configured.PodSubnet)
+	}
+	if configured.PodSubnet6 != DefaultPodSubnet6 || configured.ServiceSubnet6 != DefaultServiceSubnet6 {
+		t.Errorf("unexpected ipv6 defaults with dual-stack %+v", configured)
+	}
+}
+
+func TestConfigNetworkValidate(t *testing.T) {
+	valid := func() ConfigNetwork {
+		return ConfigNetwork{
+			IPRange:       "10.0.0.0/16",
+			NodeSubnets:   []string{"10.0.0.0/24", "10.0.1.0/24"},
+			PodSubnet:     "10.244.0.0/16",
+			ServiceSubnet: "10.96.0.0/12",
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(c *ConfigNetwork)
+		err    bool
+	}{
+		{name: "valid", modify: func(c *ConfigNetwork) {}},
+		{name: "invalid ip range", modify: func(c *ConfigNetwork) { c.IPRange = "10.0.0.0" }, err: true},
+		{name: "no node subnets", modify: func(c *ConfigNetwork) { c.NodeSubnets = nil }, err: true},
+		{name: "invalid node subnet", modify: func(c *ConfigNetwork) { c.NodeSubnets = []string{"10.0.0.0/33"} }, err: true},
+		{name: "node subnet outside ip range", modify: func(c *ConfigNetwork) { c.NodeSubnets = []string{"10.1.0.0/24"} }, err: true},
+		{name: "overlapping node subnets", modify: func(c *ConfigNetwork) { c.NodeSubnets = []string{"10.0.0.0/23", "10.0.1.0/24"} }, err: true},
+		{name: "invalid pod subnet", modify: func(c *ConfigNetwork) { c.PodSubnet = "" }, err: true},
+		{name: "invalid service subnet", modify: func(c *ConfigNetwork) { c.ServiceSubnet = "" }, err: true},
+		{name: "pod subnet overlaps node subnet", modify: func(c *ConfigNetwork) { c.PodSubnet = "10.0.0.0/8" }, err: true},
+		{name: "service subnet overlaps node subnet", modify: func(c *ConfigNetwork) { c.ServiceSubnet = "10.0.0.0/20" }, err: true},
+		{name: "pod subnet overlaps service subnet", modify: func(c *ConfigNetwork) { c.PodSubnet = "10.100.0.0/16" }, err: true},
+		{name: "overlay pod subnet inside ip range", modify: func(c *ConfigNetwork) { c.PodSubnet = "10.0.128.0/17" }, err: true},
+		{name: "native pod subnet inside ip range", modify: func(c *ConfigNetwork) { c.PodRouting = "native"; c.PodSubnet = "10.0.128.0/17" }},
+		{name: "native pod subnet outside ip range", modify: func(c *ConfigNetwork) { c.PodRouting = "native" }, err: true},
+		{name: "dual-stack", modify: func(c *ConfigNetwork) {
+			c.DualStack = true
+			c.PodSubnet6 = DefaultPodSubnet6
+			c.ServiceSubnet6 = DefaultServiceSubnet6
+		}},
+		{name: "dual-stack with ipv4 pod subnet", modify: func(c *ConfigNetwork) {
+			c.DualStack = true
+			c.PodSubnet6 = "10.245.0.0/16"
+			c.ServiceSubnet6 = DefaultServiceSubnet6
+		}, err: true},
+		{name: "dual-stack with overlapping ipv6 subnets", modify: func(c *ConfigNetwork) {
+			c.DualStack = true
+			c.PodSubnet6 = "fd00:10::/32"
+			c.ServiceSubnet6 = DefaultServiceSubnet6
+		}, err: true},
+	}
+	for _, test := range tests {
+		network := valid()
+		test.modify(&network)
+		err := network.Validate()
+		if test.err && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if !test.err && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestConfigFirewallRuleValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ConfigFirewallRule
+		err  bool
+	}{
+		{name: "tcp", rule: ConfigFirewallRule{Protocol: "tcp", Port: "443", SourceIPs: []string{"0.0.0.0/0", "::/0"}}},
+		{name: "udp port range", rule: ConfigFirewallRule{Protocol: "udp", Port: "30000-32767", SourceIPs: []string{"10.1.0.0/16"}}},
+		{name: "icmp", rule: ConfigFirewallRule{Protocol: "icmp", SourceIPs: []string{"0.0.0.0/0"}}},
+		{name: "tcp without port", rule: ConfigFirewallRule{Protocol: "tcp", SourceIPs: []string{"0.0.0.0/0"}}, err: true},
+		{name: "icmp with port", rule: ConfigFirewallRule{Protocol: "icmp", Port: "80", SourceIPs: []string{"0.0.0.0/0"}}, err: true},
+		{name: "unknown protocol", rule: ConfigFirewallRule{Protocol: "sctp", Port: "80", SourceIPs: []string{"0.0.0.0/0"}}, err: true},
+		{name: "no source IPs", rule: ConfigFirewallRule{Protocol: "tcp", Port: "80"}, err: true},
+		{name: "invalid source IP", rule: ConfigFirewallRule{Protocol: "tcp", Port: "80", SourceIPs: []string{"1.2.3.4"}}, err: true},
+		{name: "worker role with pool", rule: ConfigFirewallRule{Protocol: "tcp", Port: "80", SourceIPs: []string{"0.0.0.0/0"}, Role: "worker", Pool: "ingress"}},
+		{name: "pool without role", rule: ConfigFirewallRule{Protocol: "tcp", Port: "80", SourceIPs: []string{"0.0.0.0/0"}, Pool: "ingress"}},
+		{name: "unknown role", rule: ConfigFirewallRule{Protocol: "tcp", Port: "80", SourceIPs: []string{"0.0.0.0/0"}, Role: "bastion"}, err: true},
+		{name: "controlplane role with pool", rule: ConfigFirewallRule{Protocol: "tcp", Port: "80", SourceIPs: []string{"0.0.0.0/0"}, Role: "controlplane", Pool: "ingress"}, err: true},
+	}
+	for _, test := range tests {
+		err := test.rule.Validate()
+		if test.err && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if !test.err && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestConfigIngressValidate(t *testing.T) {
+	pools := []ConfigPool{{Name: "default"}, {Name: "ingress"}}
+	tests := []struct {
+		name    string
+		ingress ConfigIngress
+		err     bool
+	}{
+		{name: "disabled with unknown pool", ingress: ConfigIngress{Pool: "unknown"}},
+		{name: "without pool", ingress: ConfigIngress{Enabled: true}},
+		{name: "declared pool", ingress: ConfigIngress{Enabled: true, Pool: "ingress"}},
+		{name: "unknown pool", ingress: ConfigIngress{Enabled: true, Pool: "unknown"}, err: true},
+	}
+	for _, test := range tests {
+		err := test.ingress.Validate(pools)
+		if test.err && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if !test.err && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestConfigPoolValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		pool ConfigPool
+		err  bool
+	}{
+		{name: "empty", pool: ConfigPool{Name: "default"}},
+		{name: "ipv6", pool: ConfigPool{Name: "default", PublicNet: "ipv6"}},
+		{name: "unknown public net", pool: ConfigPool{Name: "default", PublicNet: "ipv4"}, err: true},
+		{name: "no public net without snapshot", pool: ConfigPool{Name: "default", PublicNet: "none"}, err: true},
+		{name: "no public net with snapshot", pool: ConfigPool{Name: "default", PublicNet: "none", ImageSnapshot: "talos"}},
+		{name: "taints", pool: ConfigPool{Name: "gpu", Taints: []ConfigTaint{
+			{Key: "example.com/gpu", Value: "true", Effect: "NoSchedule"},
+			{Key: "example.com/spot", Effect: "PreferNoSchedule"},
+		}}},
+		{name: "taint without key", pool: ConfigPool{Name: "gpu", Taints: []ConfigTaint{{Effect: "NoSchedule"}}}, err: true},
+		{name: "taint with unknown effect", pool: ConfigPool{Name: "gpu", Taints: []ConfigTaint{{Key: "example.com/gpu", Effect: "NoRun"}}}, err: true},
+		{name: "taints with the same key", pool: ConfigPool{Name: "gpu", Taints: []ConfigTaint{
+			{Key: "example.com/gpu", Value: "true", Effect: "NoSchedule"},
+			{Key: "example.com/gpu", Value: "true", Effect: "NoExecute"},
+		}}, err: true},
+	}
+	for _, test := range tests {
+		err := test.pool.Validate()
+		if test.err && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if !test.err && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
@@ -17,6 +17,29 @@ type Cluster struct {
 	Logger *utils.Logger
 	Dir    string
 	Config Config
+	// Created, Deleted and Updated record the resources changed by the
+	// current command, to report them as its result
+	Created []Resource
+	Deleted []Resource
+	Updated []Resource
+}
+
+type Resource struct {
+	Type string `json:"type" yaml:"type"`
+	Name string `json:"name" yaml:"name"`
+	ID   int    `json:"id,omitempty" yaml:"id,omitempty"`
+}
+
+func (cl *Cluster) RecordCreated(resourceType string, name string, id int) {
+	cl.Created = append(cl.Created, Resource{Type: resourceType, Name: name, ID: id})
+}
+
+func (cl *Cluster) RecordDeleted(resourceType string, name string, id int) {
+	cl.Deleted = append(cl.Deleted, Resource{Type: resourceType, Name: name, ID: id})
+}
+
+func (cl *Cluster) RecordUpdated(resourceType string, name string, id int) {
+	cl.Updated = append(cl.Updated, Resource{Type: resourceType, Name: name, ID: id})
 }
 
 func (cl *Cluster) Create(logger *utils.Logger, clusterName string, hcloudLocation string, hcloudNetworkZone string, hcloudToken string) error {
@@ -35,14 +58,24 @@ func (cl *Cluster) Create(logger *utils.Logger, clusterName string, hcloudLocati
 		return fmt.Errorf("directory must be empty")
 	}
 
+	cl.Init(logger, clusterName, hcloudLocation, hcloudNetworkZone, hcloudToken)
+
+	return nil
+}
+
+// Init prepares a cluster without touching the cluster directory, e.g. to
+// run checks before it is bootstrapped.
+func (cl *Cluster) Init(logger *utils.Logger, clusterName string, hcloudLocation string, hcloudNetworkZone string, hcloudToken string) {
+	ctx := context.Background()
+	cl.Ctx = &ctx
+	cl.Logger = logger
+
 	cl.Config.ClusterName = clusterName
 	cl.Config.Hcloud.Location = hcloudLocation
 	cl.Config.Hcloud.NetworkZone = hcloudNetworkZone
 	cl.Config.Hcloud.Token = hcloudToken
 
 	cl.Client = hcloud.NewClient(hcloud.WithToken(hcloudToken))
-
-	return nil
 }
 
 func (cl *Cluster) Load(configFile string, logger *utils.Logger) error {
@@ -58,6 +91,8 @@ func (cl *Cluster) Load(configFile string, logger *utils.Logger) error {
 	if err != nil {
 		return err
 	}
+	// clusters bootstrapped before the network layout became configurable
+	cl.Config.Network.SetDefaults()
 
 	cl.Client = hcloud.NewClient(hcloud.WithToken(cl.Config.Hcloud.Token))
 
@@ -3,6 +3,7 @@ package internal
 import (
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/airfocusio/hcloud-talos/internal/clients"
 	"github.com/airfocusio/hcloud-talos/internal/cluster"
@@ -17,40 +18,51 @@ type DeleteNodeOpts struct {
 	Force      bool
 }
 
-func DeleteNode(logger *utils.Logger, dir string, opts DeleteNodeOpts) error {
+func DeleteNode(logger *utils.Logger, dir string, opts DeleteNodeOpts) (result *Result, err error) {
+	start := time.Now()
 	cl := &cluster.Cluster{Dir: dir}
-	err := cl.Load(opts.ConfigFile, logger)
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logger.Info.Printf("Deleting node %s/%s\n", cl.Config.ClusterName, opts.NodeName)
 	if opts.NodeName == "" {
-		return fmt.Errorf("node name must not be empty")
+		return nil, fmt.Errorf("node name must not be empty")
 	}
 	if !opts.Force {
-		return fmt.Errorf("deleting a node must be forced")
+		return nil, fmt.Errorf("deleting a node must be forced")
 	}
 
 	serverName := nodeName(cl, opts.NodeName)
 	server, _, err := cl.Client.Server.Get(*cl.Ctx, serverName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if server == nil {
-		return fmt.Errorf("server %q could not be found", serverName)
+		return nil, fmt.Errorf("server %q could not be found", serverName)
 	}
 	if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
-		return fmt.Errorf("server %q private IP could not be determined", serverName)
+		return nil, fmt.Errorf("server %q private IP could not be determined", serverName)
 	}
 	serverIP := server.PrivateNet[0].IP
 
+	err = ensureControlplaneFloatingIPAssigned(cl, server.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	logger.Debug.Printf("Resetting talos\n")
 	err = utils.Retry(cl.Logger, func() error {
 		_, err := TalosReset(cl, serverIP)
 		return err
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	logger.Debug.Printf("Waiting for server to shut down talos\n")
@@ -73,18 +85,45 @@ func DeleteNode(logger *utils.Logger, dir string, opts DeleteNodeOpts) error {
 		return err
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	cl.RecordDeleted("kubernetes-node", serverName, 0)
 
 	if !opts.KeepServer {
+		var deleteResult *hcloud.ServerDeleteResult
 		err = utils.Retry(cl.Logger, func() error {
-			_, err := cl.Client.Server.Delete(*cl.Ctx, server)
+			var err error
+			deleteResult, _, err = cl.Client.Server.DeleteWithResult(*cl.Ctx, server)
 			return err
 		})
 		if err != nil {
-			return err
+			return nil, err
+		}
+		// the placement group keeps listing the server until it is gone
+		err = cl.Client.Action.WaitFor(*cl.Ctx, deleteResult.Action)
+		if err != nil {
+			return nil, err
+		}
+		cl.RecordDeleted("server", server.Name, server.ID)
+
+		if server.PlacementGroup != nil {
+			err = deleteEmptyWorkerPlacementGroup(cl, server.PlacementGroup.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		network, err := ensureNodeNetwork(cl, false)
+		if err != nil {
+			return nil, err
+		}
+		_, err = reconcileFirewalls(cl, network, true)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	result = newResult(cl, start)
+	result.addNode(server, time.Time{})
+	return result, nil
 }
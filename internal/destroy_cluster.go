@@ -2,6 +2,9 @@ package internal
 
 import (
 	"fmt"
+	"net"
+	"slices"
+	"time"
 
 	"github.com/airfocusio/hcloud-talos/internal/cluster"
 	"github.com/airfocusio/hcloud-talos/internal/utils"
@@ -13,16 +16,22 @@ type DestroyClusterOpts struct {
 	Force      bool
 }
 
-func DestroyCluster(logger *utils.Logger, dir string, opts DestroyClusterOpts) error {
+func DestroyCluster(logger *utils.Logger, dir string, opts DestroyClusterOpts) (result *Result, err error) {
+	start := time.Now()
 	cl := &cluster.Cluster{Dir: dir}
-	err := cl.Load(opts.ConfigFile, logger)
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logger.Info.Printf("Destroying cluster %q\n", cl.Config.ClusterName)
 
 	if !opts.Force {
-		return fmt.Errorf("destroying the cluster must be forced")
+		return nil, fmt.Errorf("destroying the cluster must be forced")
 	}
 
 	servers, _, err := cl.Client.Server.List(*cl.Ctx, hcloud.ServerListOpts{
@@ -33,6 +42,18 @@ func DestroyCluster(logger *utils.Logger, dir string, opts DestroyClusterOpts) e
 	if err != nil {
 		logger.Warn.Printf("Error: %v\n", err)
 	}
+	// routes on an existing network are recognized by their gateway, which
+	// must be determined before the servers are gone
+	nodeIPs := []net.IP{}
+	natIPs := []net.IP{}
+	for _, server := range servers {
+		if server.Labels[roleLabel] != "" {
+			nodeIPs = append(nodeIPs, serverPrivateIPs(server)...)
+		}
+		if server.Labels[natLabel] != "" {
+			natIPs = append(natIPs, serverPrivateIPs(server)...)
+		}
+	}
 	for _, server := range servers {
 		cl.Logger.Info.Printf("Deleting server %d\n", server.ID)
 		err := utils.Retry(cl.Logger, func() error {
@@ -41,7 +62,9 @@ func DestroyCluster(logger *utils.Logger, dir string, opts DestroyClusterOpts) e
 		})
 		if err != nil {
 			logger.Warn.Printf("Error: %v\n", err)
+			continue
 		}
+		cl.RecordDeleted("server", server.Name, server.ID)
 	}
 
 	firewalls, _, err := cl.Client.Firewall.List(*cl.Ctx, hcloud.FirewallListOpts{
@@ -60,7 +83,30 @@ func DestroyCluster(logger *utils.Logger, dir string, opts DestroyClusterOpts) e
 		})
 		if err != nil {
 			logger.Warn.Printf("Error: %v\n", err)
+			continue
+		}
+		cl.RecordDeleted("firewall", firewall.Name, firewall.ID)
+	}
+
+	floatingIPs, _, err := cl.Client.FloatingIP.List(*cl.Ctx, hcloud.FloatingIPListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName,
+		},
+	})
+	if err != nil {
+		logger.Warn.Printf("Error: %v\n", err)
+	}
+	for _, floatingIP := range floatingIPs {
+		cl.Logger.Info.Printf("Deleting floating IP %d\n", floatingIP.ID)
+		err := utils.Retry(cl.Logger, func() error {
+			_, err := cl.Client.FloatingIP.Delete(*cl.Ctx, floatingIP)
+			return err
+		})
+		if err != nil {
+			logger.Warn.Printf("Error: %v\n", err)
+			continue
 		}
+		cl.RecordDeleted("floating-ip", floatingIP.Name, floatingIP.ID)
 	}
 
 	loadBalancers, _, err := cl.Client.LoadBalancer.List(*cl.Ctx, hcloud.LoadBalancerListOpts{
@@ -79,7 +125,9 @@ func DestroyCluster(logger *utils.Logger, dir string, opts DestroyClusterOpts) e
 		})
 		if err != nil {
 			logger.Warn.Printf("Error: %v\n", err)
+			continue
 		}
+		cl.RecordDeleted("load-balancer", loadBalancer.Name, loadBalancer.ID)
 	}
 
 	placementGroups, _, err := cl.Client.PlacementGroup.List(*cl.Ctx, hcloud.PlacementGroupListOpts{
@@ -96,6 +144,36 @@ func DestroyCluster(logger *utils.Logger, dir string, opts DestroyClusterOpts) e
 			_, err := cl.Client.PlacementGroup.Delete(*cl.Ctx, placementGroup)
 			return err
 		})
+		if err != nil {
+			logger.Warn.Printf("Error: %v\n", err)
+			continue
+		}
+		cl.RecordDeleted("placement-group", placementGroup.Name, placementGroup.ID)
+	}
+
+	sshKeys, err := cl.Client.SSHKey.AllWithOpts(*cl.Ctx, hcloud.SSHKeyListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName,
+		},
+	})
+	if err != nil {
+		logger.Warn.Printf("Error: %v\n", err)
+	}
+	for _, sshKey := range sshKeys {
+		cl.Logger.Info.Printf("Deleting SSH key %d\n", sshKey.ID)
+		err := utils.Retry(cl.Logger, func() error {
+			_, err := cl.Client.SSHKey.Delete(*cl.Ctx, sshKey)
+			return err
+		})
+		if err != nil {
+			logger.Warn.Printf("Error: %v\n", err)
+			continue
+		}
+		cl.RecordDeleted("ssh-key", sshKey.Name, sshKey.ID)
+	}
+
+	if cl.Config.Network.ExistingNetwork != "" {
+		err := removeFromExistingNetwork(cl, nodeIPs, natIPs)
 		if err != nil {
 			logger.Warn.Printf("Error: %v\n", err)
 		}
@@ -117,8 +195,80 @@ func DestroyCluster(logger *utils.Logger, dir string, opts DestroyClusterOpts) e
 		})
 		if err != nil {
 			logger.Warn.Printf("Error: %v\n", err)
+			continue
+		}
+		cl.RecordDeleted("network", network.Name, network.ID)
+	}
+
+	return newResult(cl, start), nil
+}
+
+// removeFromExistingNetwork removes the node subnets, the routes the cloud
+// controller manager created for the pod subnet and the default route via the
+// NAT gateway from a network that is not owned by the cluster and hence not
+// deleted together with it. Routes are only removed if they lead to one of the
+// given node or NAT gateway IPs, as other tenants of the network may route the
+// same ranges.
+func removeFromExistingNetwork(cl *cluster.Cluster, nodeIPs []net.IP, natIPs []net.IP) error {
+	network, _, err := cl.Client.Network.Get(*cl.Ctx, cl.Config.Network.ExistingNetwork)
+	if err != nil {
+		return err
+	}
+	if network == nil {
+		return fmt.Errorf("existing network %q could not be found", cl.Config.Network.ExistingNetwork)
+	}
+
+	_, podSubnet, _ := net.ParseCIDR(cl.Config.Network.PodSubnet)
+	for _, route := range network.Routes {
+		isPodRoute := podSubnet != nil && podSubnet.Contains(route.Destination.IP) && containsIP(nodeIPs, route.Gateway)
+		isNatGatewayRoute := route.Destination.String() == "0.0.0.0/0" && containsIP(natIPs, route.Gateway)
+		if !isPodRoute && !isNatGatewayRoute {
+			continue
+		}
+		cl.Logger.Info.Printf("Deleting route %s from network %d\n", route.Destination, network.ID)
+		err := utils.Retry(cl.Logger, func() error {
+			_, _, err := cl.Client.Network.DeleteRoute(*cl.Ctx, network, hcloud.NetworkDeleteRouteOpts{
+				Route: route,
+			})
+			return err
+		})
+		if err != nil {
+			cl.Logger.Warn.Printf("Error: %v\n", err)
+		}
+	}
+
+	for _, subnet := range network.Subnets {
+		if !slices.Contains(cl.Config.Network.NodeSubnets, subnet.IPRange.String()) {
+			continue
+		}
+		cl.Logger.Info.Printf("Deleting subnet %s from network %d\n", subnet.IPRange, network.ID)
+		err := utils.Retry(cl.Logger, func() error {
+			_, _, err := cl.Client.Network.DeleteSubnet(*cl.Ctx, network, hcloud.NetworkDeleteSubnetOpts{
+				Subnet: subnet,
+			})
+			return err
+		})
+		if err != nil {
+			cl.Logger.Warn.Printf("Error: %v\n", err)
 		}
 	}
 
 	return nil
 }
+
+func serverPrivateIPs(server *hcloud.Server) []net.IP {
+	ips := []net.IP{}
+	for _, privateNet := range server.PrivateNet {
+		ips = append(ips, privateNet.IP)
+	}
+	return ips
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
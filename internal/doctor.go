@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	v1 "k8s.io/api/core/v1"
+)
+
+type DoctorOpts struct {
+	ConfigFile string
+}
+
+type doctorFinding struct {
+	problem    string
+	suggestion string
+}
+
+func Doctor(logger *utils.Logger, dir string, opts DoctorOpts) error {
+	cl := &cluster.Cluster{Dir: dir}
+	err := cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return err
+	}
+	logger.Info.Printf("Diagnosing cluster %s\n", cl.Config.ClusterName)
+
+	findings := []doctorFinding{}
+	report := func(suggestion string, format string, args ...interface{}) {
+		findings = append(findings, doctorFinding{problem: fmt.Sprintf(format, args...), suggestion: suggestion})
+	}
+
+	servers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	serverNames := map[int]string{}
+	nodeServers := map[string]*hcloud.Server{}
+	controlplaneServers := []*hcloud.Server{}
+	for _, server := range servers {
+		serverNames[server.ID] = server.Name
+		if server.Labels[natLabel] != "" || server.Labels[bastionLabel] != "" {
+			if server.Status != hcloud.ServerStatusRunning {
+				report("power on the server in the hcloud console", "server %s is %s", server.Name, server.Status)
+			}
+			continue
+		}
+		role := server.Labels[roleLabel]
+		if role == "" {
+			report("the role label is set once the Talos image is written, delete the server if its creation was aborted", "server %s has no role label", server.Name)
+			continue
+		}
+		nodeServers[server.Name] = server
+		if server.Status != hcloud.ServerStatusRunning {
+			report("power on the server in the hcloud console or delete it with delete-node", "server %s is %s", server.Name, server.Status)
+		}
+		if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
+			report("attach the server to the network or recreate the node", "server %s has no private IP", server.Name)
+			continue
+		}
+		if role == "controlplane" {
+			controlplaneServers = append(controlplaneServers, server)
+		}
+	}
+	logger.Debug.Printf("Found %d node servers\n", len(nodeServers))
+
+	network, err := ensureNodeNetwork(cl, false)
+	if err != nil {
+		return err
+	}
+	loadBalancerTemplates := []hcloud.LoadBalancerCreateOpts{}
+	if !cl.Config.IsFloatingIPEndpoint() {
+		loadBalancerTemplates = append(loadBalancerTemplates, controlplaneLoadBalanacerTemplate(cl, network))
+	}
+	if cl.Config.Ingress.Enabled {
+		loadBalancerTemplates = append(loadBalancerTemplates, ingressLoadBalancerTemplate(cl, network))
+	}
+	for _, tmpl := range loadBalancerTemplates {
+		loadBalancer, _, err := cl.Client.LoadBalancer.Get(*cl.Ctx, tmpl.Name)
+		if err != nil {
+			return err
+		}
+		if loadBalancer == nil {
+			report("run check-drift --repair", "load balancer %s is missing", tmpl.Name)
+			continue
+		}
+		for _, target := range loadBalancer.Targets {
+			for _, resolvedTarget := range append([]hcloud.LoadBalancerTarget{target}, target.Targets...) {
+				if resolvedTarget.Server == nil || resolvedTarget.Server.Server == nil {
+					continue
+				}
+				for _, healthStatus := range resolvedTarget.HealthStatus {
+					if healthStatus.Status != hcloud.LoadBalancerTargetHealthStatusStatusHealthy {
+						serverName := serverNames[resolvedTarget.Server.Server.ID]
+						if serverName == "" {
+							serverName = fmt.Sprintf("%d", resolvedTarget.Server.Server.ID)
+						}
+						report("check the node with talosctl health or recreate it", "load balancer %s target %s is %s on port %d", loadBalancer.Name, serverName, healthStatus.Status, healthStatus.ListenPort)
+					}
+				}
+			}
+		}
+	}
+
+	etcdMembers := map[string]bool{}
+	etcdMembersKnown := false
+	for _, server := range controlplaneServers {
+		serverIP := server.PrivateNet[0].IP
+		services, err := TalosServices(cl, serverIP)
+		if err != nil {
+			report("check that the node is up and the Talos API is reachable", "talos API of %s is not reachable: %v", server.Name, err)
+			continue
+		}
+		for _, service := range services {
+			if service.State != "Running" && service.State != "Finished" {
+				report(fmt.Sprintf("inspect with talosctl -n %s logs %s", serverIP, service.ID), "talos service %s on %s is %s", service.ID, server.Name, service.State)
+			} else if service.Health != "OK" && service.Health != "?" {
+				report(fmt.Sprintf("inspect with talosctl -n %s logs %s", serverIP, service.ID), "talos service %s on %s is unhealthy", service.ID, server.Name)
+			}
+		}
+		if etcdMembersKnown {
+			continue
+		}
+		members, err := TalosEtcdMembers(cl, serverIP)
+		if err != nil {
+			report("inspect with talosctl etcd status", "etcd members could not be listed on %s: %v", server.Name, err)
+			continue
+		}
+		for _, member := range members {
+			etcdMembers[member] = true
+		}
+		etcdMembersKnown = true
+	}
+	if etcdMembersKnown {
+		for _, server := range controlplaneServers {
+			if !etcdMembers[server.Name] {
+				report("reset the node with delete-node and add a new controlplane", "controlplane %s is no etcd member", server.Name)
+			}
+			delete(etcdMembers, server.Name)
+		}
+		for member := range etcdMembers {
+			report(fmt.Sprintf("remove it with talosctl etcd remove-member %s", member), "etcd member %s has no controlplane server", member)
+		}
+	}
+
+	nodes, err := clients.KubernetesListNodes(cl)
+	if err != nil {
+		report("check that the Kubernetes API is reachable", "kubernetes nodes could not be listed: %v", err)
+	} else {
+		nodeNames := map[string]bool{}
+		for _, node := range nodes {
+			nodeNames[node.Name] = true
+			server, ok := nodeServers[node.Name]
+			if !ok {
+				report(fmt.Sprintf("delete it with kubectl delete node %s", node.Name), "kubernetes node %s has no server", node.Name)
+				continue
+			}
+			if node.Labels[roleLabel] != server.Labels[roleLabel] {
+				report("run apply-config", "kubernetes node %s has role label %q instead of %q", node.Name, node.Labels[roleLabel], server.Labels[roleLabel])
+			}
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == v1.NodeReady && condition.Status != v1.ConditionTrue {
+					report(fmt.Sprintf("inspect with kubectl describe node %s", node.Name), "kubernetes node %s is not ready: %s", node.Name, condition.Message)
+				}
+				if condition.Type != v1.NodeReady && condition.Status == v1.ConditionTrue {
+					report(fmt.Sprintf("inspect with kubectl describe node %s", node.Name), "kubernetes node %s has condition %s: %s", node.Name, condition.Type, condition.Message)
+				}
+			}
+		}
+		for name, server := range nodeServers {
+			if !nodeNames[name] && server.Status == hcloud.ServerStatusRunning {
+				report("inspect with talosctl dmesg or recreate the node", "server %s has no kubernetes node", name)
+			}
+		}
+	}
+
+	for _, finding := range findings {
+		logger.Warn.Printf("%s (%s)\n", finding.problem, finding.suggestion)
+	}
+	if len(findings) > 0 {
+		return fmt.Errorf("%d problems found", len(findings))
+	}
+	logger.Info.Printf("No problems found\n")
+	return nil
+}
@@ -0,0 +1,319 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	"github.com/minio/minio-go/v7"
+)
+
+const etcdBackupTimeFormat = "20060102T150405Z"
+
+type EtcdBackupOpts struct {
+	ConfigFile string
+	TargetDir  string
+	S3         EtcdBackupS3Opts
+	Keep       int
+	KeepWithin time.Duration
+}
+
+type EtcdBackupS3Opts struct {
+	clients.S3Opts
+	Bucket string
+	Prefix string
+}
+
+func EtcdBackup(logger *utils.Logger, dir string, opts EtcdBackupOpts) (result *Result, err error) {
+	start := time.Now()
+	cl := &cluster.Cluster{Dir: dir}
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Backing up etcd of cluster %s\n", cl.Config.ClusterName)
+	if opts.Keep < 0 {
+		return nil, fmt.Errorf("keep must not be negative")
+	}
+
+	storage, err := newEtcdBackupStorage(cl, opts.TargetDir, opts.S3)
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "hcloud-talos-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	now := time.Now().UTC()
+	snapshotName := fmt.Sprintf("%s-etcd-%s.snapshot", cl.Config.ClusterName, now.Format(etcdBackupTimeFormat))
+	snapshotFile := path.Join(tempDir, snapshotName)
+	err = etcdSnapshotFromHealthyControlplane(cl, snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum, err := fileSha256(snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	checksumFile := snapshotFile + ".sha256"
+	err = os.WriteFile(checksumFile, []byte(fmt.Sprintf("%s  %s\n", checksum, snapshotName)), 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info.Printf("Storing snapshot %s (sha256 %s)\n", snapshotName, checksum)
+	err = storage.Put(snapshotName, snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	err = storage.Put(snapshotName+".sha256", checksumFile)
+	if err != nil {
+		return nil, err
+	}
+	cl.RecordCreated("etcd-snapshot", snapshotName, 0)
+
+	err = pruneEtcdBackups(cl, storage, now, opts.Keep, opts.KeepWithin)
+	if err != nil {
+		return nil, err
+	}
+	return newResult(cl, start), nil
+}
+
+func etcdSnapshotFromHealthyControlplane(cl *cluster.Cluster, snapshotFile string) error {
+	servers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=controlplane",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, server := range servers {
+		if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
+			continue
+		}
+		serverIP := server.PrivateNet[0].IP
+		_, err := TalosEtcdStatus(cl, serverIP)
+		if err != nil {
+			cl.Logger.Warn.Printf("Skipping unhealthy controlplane %s: %v\n", server.Name, err)
+			continue
+		}
+		cl.Logger.Debug.Printf("Taking etcd snapshot from controlplane %s\n", server.Name)
+		_, err = TalosEtcdSnapshot(cl, serverIP, snapshotFile)
+		if err != nil {
+			cl.Logger.Warn.Printf("Taking etcd snapshot from controlplane %s failed: %v\n", server.Name, err)
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no healthy controlplane found to take etcd snapshot from")
+}
+
+func pruneEtcdBackups(cl *cluster.Cluster, storage etcdBackupStorage, now time.Time, keep int, keepWithin time.Duration) error {
+	names, err := storage.List()
+	if err != nil {
+		return err
+	}
+	prefix := cl.Config.ClusterName + "-etcd-"
+	type snapshot struct {
+		name string
+		time time.Time
+	}
+	snapshots := []snapshot{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".snapshot") {
+			continue
+		}
+		t, err := time.Parse(etcdBackupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".snapshot"))
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{name: name, time: t})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].time.After(snapshots[j].time)
+	})
+
+	// the newest snapshot is never pruned
+	for i, s := range snapshots {
+		if i == 0 {
+			continue
+		}
+		if (keep > 0 && i >= keep) || (keepWithin > 0 && now.Sub(s.time) > keepWithin) {
+			cl.Logger.Info.Printf("Pruning snapshot %s\n", s.name)
+			err := storage.Delete(s.name)
+			if err != nil {
+				return err
+			}
+			err = storage.Delete(s.name + ".sha256")
+			if err != nil {
+				return err
+			}
+			cl.RecordDeleted("etcd-snapshot", s.name, 0)
+		}
+	}
+	return nil
+}
+
+// verifyEtcdSnapshotChecksum compares the snapshot with the checksum file
+// written next to it by etcd-backup.
+func verifyEtcdSnapshotChecksum(snapshotFile string, checksumFile string) error {
+	checksumBytes, err := os.ReadFile(checksumFile)
+	if err != nil {
+		return fmt.Errorf("checksum file of snapshot could not be read: %w", err)
+	}
+	fields := strings.Fields(string(checksumBytes))
+	if len(fields) == 0 {
+		return fmt.Errorf("checksum file %s is empty", checksumFile)
+	}
+	checksum, err := fileSha256(snapshotFile)
+	if err != nil {
+		return err
+	}
+	if checksum != fields[0] {
+		return fmt.Errorf("snapshot has sha256 %s, but checksum file expects %s", checksum, fields[0])
+	}
+	return nil
+}
+
+func fileSha256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type etcdBackupStorage interface {
+	Put(name string, file string) error
+	Get(name string, file string) error
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+func newEtcdBackupStorage(cl *cluster.Cluster, targetDir string, s3 EtcdBackupS3Opts) (etcdBackupStorage, error) {
+	if s3.Bucket != "" {
+		client, err := clients.S3Init(s3.S3Opts)
+		if err != nil {
+			return nil, err
+		}
+		return &s3EtcdBackupStorage{ctx: *cl.Ctx, client: client, bucket: s3.Bucket, prefix: s3.Prefix}, nil
+	}
+	if !path.IsAbs(targetDir) {
+		targetDir = path.Join(cl.Dir, targetDir)
+	}
+	err := os.MkdirAll(targetDir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &localEtcdBackupStorage{dir: targetDir}, nil
+}
+
+type localEtcdBackupStorage struct {
+	dir string
+}
+
+func (s *localEtcdBackupStorage) Put(name string, file string) error {
+	return copyFile(file, path.Join(s.dir, name))
+}
+
+func (s *localEtcdBackupStorage) Get(name string, file string) error {
+	return copyFile(path.Join(s.dir, name), file)
+}
+
+func (s *localEtcdBackupStorage) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *localEtcdBackupStorage) Delete(name string) error {
+	err := os.Remove(path.Join(s.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+type s3EtcdBackupStorage struct {
+	ctx    context.Context
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3EtcdBackupStorage) Put(name string, file string) error {
+	_, err := s.client.FPutObject(s.ctx, s.bucket, s.prefix+name, file, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *s3EtcdBackupStorage) Get(name string, file string) error {
+	return s.client.FGetObject(s.ctx, s.bucket, s.prefix+name, file, minio.GetObjectOptions{})
+}
+
+func (s *s3EtcdBackupStorage) List() ([]string, error) {
+	names := []string{}
+	for object := range s.client.ListObjects(s.ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		names = append(names, strings.TrimPrefix(object.Key, s.prefix))
+	}
+	return names, nil
+}
+
+func (s *s3EtcdBackupStorage) Delete(name string) error {
+	return s.client.RemoveObject(s.ctx, s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
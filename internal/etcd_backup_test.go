@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+)
+
+func TestPruneEtcdBackups(t *testing.T) {
+	now := time.Date(2024, 10, 19, 12, 0, 0, 0, time.UTC)
+	snapshot := func(age time.Duration) string {
+		return "test-etcd-" + now.Add(-age).Format(etcdBackupTimeFormat) + ".snapshot"
+	}
+	day := 24 * time.Hour
+	tests := []struct {
+		name       string
+		files      []string
+		keep       int
+		keepWithin time.Duration
+		expected   []string
+	}{
+		{
+			name:     "nothing to prune",
+			files:    []string{snapshot(0), snapshot(day)},
+			expected: []string{snapshot(0), snapshot(day)},
+		},
+		{
+			name:     "keep newest",
+			files:    []string{snapshot(2 * day), snapshot(0), snapshot(day)},
+			keep:     2,
+			expected: []string{snapshot(0), snapshot(day)},
+		},
+		{
+			name:       "keep within",
+			files:      []string{snapshot(0), snapshot(day), snapshot(3 * day)},
+			keepWithin: 2 * day,
+			expected:   []string{snapshot(0), snapshot(day)},
+		},
+		{
+			name:       "keep and keep within",
+			files:      []string{snapshot(0), snapshot(day), snapshot(2 * day)},
+			keep:       2,
+			keepWithin: 3 * day,
+			expected:   []string{snapshot(0), snapshot(day)},
+		},
+		{
+			name:       "never prune the newest",
+			files:      []string{snapshot(5 * day), snapshot(10 * day)},
+			keep:       1,
+			keepWithin: day,
+			expected:   []string{snapshot(5 * day)},
+		},
+		{
+			name:     "other files",
+			files:    []string{snapshot(0), snapshot(day), "other-etcd-" + now.Format(etcdBackupTimeFormat) + ".snapshot", "test-etcd-invalid.snapshot", "notes.txt"},
+			keep:     1,
+			expected: []string{"notes.txt", "other-etcd-" + now.Format(etcdBackupTimeFormat) + ".snapshot", snapshot(0), "test-etcd-invalid.snapshot"},
+		},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		for _, file := range test.files {
+			if err := os.WriteFile(path.Join(dir, file), []byte{}, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if path.Ext(file) == ".snapshot" {
+				if err := os.WriteFile(path.Join(dir, file+".sha256"), []byte{}, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		logger := utils.NewLogger(false)
+		cl := &cluster.Cluster{Logger: &logger, Config: cluster.Config{ClusterName: "test"}}
+		storage := &localEtcdBackupStorage{dir: dir}
+		if err := pruneEtcdBackups(cl, storage, now, test.keep, test.keepWithin); err != nil {
+			t.Errorf("%s: pruneEtcdBackups failed: %v", test.name, err)
+			continue
+		}
+
+		expected := []string{}
+		for _, file := range test.expected {
+			expected = append(expected, file)
+			if path.Ext(file) == ".snapshot" {
+				expected = append(expected, file+".sha256")
+			}
+		}
+		sort.Strings(expected)
+		actual, err := storage.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: pruneEtcdBackups left %v, expected %v", test.name, actual, expected)
+		}
+	}
+}
+
+func TestVerifyEtcdSnapshotChecksum(t *testing.T) {
+	dir := t.TempDir()
+	snapshotFile := path.Join(dir, "test.snapshot")
+	if err := os.WriteFile(snapshotFile, []byte("snapshot"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := fileSha256(snapshotFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		checksum string
+		missing  bool
+		err      bool
+	}{
+		{name: "matching", checksum: checksum + "  test.snapshot\n"},
+		{name: "checksum only", checksum: checksum},
+		{name: "mismatching", checksum: "0000000000000000000000000000000000000000000000000000000000000000  test.snapshot\n", err: true},
+		{name: "empty", checksum: "", err: true},
+		{name: "missing", missing: true, err: true},
+	}
+	for _, test := range tests {
+		checksumFile := path.Join(dir, test.name+".sha256")
+		if !test.missing {
+			if err := os.WriteFile(checksumFile, []byte(test.checksum), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := verifyEtcdSnapshotChecksum(snapshotFile, checksumFile)
+		if test.err && err == nil {
+			t.Errorf("%s: verifyEtcdSnapshotChecksum succeeded, expected error", test.name)
+		}
+		if !test.err && err != nil {
+			t.Errorf("%s: verifyEtcdSnapshotChecksum failed: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// talosKubernetesVersions is the range of Kubernetes minor versions each Talos
+// minor version supports, see https://www.talos.dev/latest/introduction/support-matrix/
+var talosKubernetesVersions = map[string][2]string{
+	"1.5":  {"1.23", "1.28"},
+	"1.6":  {"1.24", "1.29"},
+	"1.7":  {"1.25", "1.30"},
+	"1.8":  {"1.26", "1.31"},
+	"1.9":  {"1.27", "1.32"},
+	"1.10": {"1.28", "1.33"},
+	"1.11": {"1.29", "1.34"},
+}
+
+type PreflightOpts struct {
+	ConfigFile        string
+	ServerType        string
+	Controlplane      bool
+	PoolName          string
+	NodeCount         int
+	TalosVersion      string
+	KubernetesVersion string
+	Location          string
+	NetworkZone       string
+	Token             string
+}
+
+// Preflight checks a cluster, or with a location given, a cluster that is
+// yet to be bootstrapped and hence has no config file.
+func Preflight(logger *utils.Logger, dir string, opts PreflightOpts) error {
+	cl := &cluster.Cluster{Dir: dir}
+	if opts.Location != "" {
+		if opts.NetworkZone == "" {
+			return fmt.Errorf("network zone must not be empty")
+		}
+		if opts.Token == "" {
+			return fmt.Errorf("token must not be empty")
+		}
+		cl.Init(logger, "hcloud-talos", opts.Location, opts.NetworkZone, opts.Token)
+	} else {
+		err := cl.Load(opts.ConfigFile, logger)
+		if err != nil {
+			return err
+		}
+	}
+	pool := cl.Config.FindPool(opts.PoolName)
+	if opts.Controlplane {
+		pool = cl.Config.Controlplane
+	}
+	return preflight(cl, preflightChecks{
+		ServerType:        opts.ServerType,
+		Pool:              pool,
+		NodeCount:         opts.NodeCount,
+		TalosVersion:      opts.TalosVersion,
+		KubernetesVersion: opts.KubernetesVersion,
+	})
+}
+
+type preflightChecks struct {
+	ServerType        string
+	Pool              cluster.ConfigPool
+	NodeCount         int
+	TalosVersion      string
+	KubernetesVersion string
+	// InfraServerTypes are the server types of the NAT gateway and the
+	// bastion, which are created in the primary location
+	InfraServerTypes []string
+}
+
+// preflight validates everything that would otherwise only fail halfway
+// through creating resources. All problems are logged before failing.
+func preflight(cl *cluster.Cluster, checks preflightChecks) error {
+	cl.Logger.Info.Printf("Running preflight checks\n")
+	failed := 0
+	report := func(err error) {
+		if err != nil {
+			cl.Logger.Error.Printf("Preflight: %v\n", err)
+			failed++
+		}
+	}
+
+	report(preflightToken(cl))
+
+	locations := cl.Config.PoolLocations(checks.Pool)
+	locationsErr := clients.HcloudValidateLocations(cl, locations)
+	report(locationsErr)
+
+	if checks.ServerType != "" && locationsErr == nil {
+		serverTypes := []string{checks.ServerType}
+		for _, serverType := range checks.Pool.ServerTypes {
+			if !slices.Contains(serverTypes, serverType) {
+				serverTypes = append(serverTypes, serverType)
+			}
+		}
+		for _, serverType := range serverTypes {
+			report(preflightServerType(cl, serverType, locations, true))
+		}
+	}
+	if locationsErr == nil {
+		for _, serverType := range checks.InfraServerTypes {
+			report(preflightServerType(cl, serverType, []string{cl.Config.Hcloud.Location}, false))
+		}
+	}
+
+	if checks.NodeCount > 0 {
+		report(preflightServerLimit(cl, checks.NodeCount))
+	}
+
+	if checks.TalosVersion != "" {
+		report(preflightTalosctlVersion(cl, checks.TalosVersion))
+	}
+	if checks.TalosVersion != "" && checks.KubernetesVersion != "" {
+		report(preflightKubernetesVersion(cl, checks.TalosVersion, checks.KubernetesVersion))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d preflight checks failed", failed)
+	}
+	return nil
+}
+
+// preflightToken ensures that the token is valid and allowed to write, by
+// creating and deleting a temporary SSH key. It carries the cluster label, so
+// that destroy-cluster removes it should the deletion fail.
+func preflightToken(cl *cluster.Cluster) error {
+	sshKeyPrivate := clients.SSHKeyPrivate{}
+	if err := sshKeyPrivate.Generate(); err != nil {
+		return err
+	}
+	sshKeyPublic, err := sshKeyPrivate.StorePublic()
+	if err != nil {
+		return err
+	}
+	sshKey, _, err := cl.Client.SSHKey.Create(*cl.Ctx, hcloud.SSHKeyCreateOpts{
+		Name:      cl.Config.ClusterName + "-preflight-" + utils.RandString(8),
+		PublicKey: sshKeyPublic,
+		Labels:    map[string]string{clusterLabel: cl.Config.ClusterName},
+	})
+	if hcloud.IsError(err, hcloud.ErrorCodeUnauthorized) {
+		return fmt.Errorf("hcloud token is invalid")
+	}
+	if hcloud.IsError(err, hcloud.ErrorCodeForbidden) {
+		return fmt.Errorf("hcloud token is read only")
+	}
+	if err != nil {
+		return err
+	}
+	err = utils.Retry(cl.Logger, func() error {
+		_, err := cl.Client.SSHKey.Delete(*cl.Ctx, sshKey)
+		return err
+	})
+	if err != nil {
+		cl.Logger.Warn.Printf("Temporary SSH key %q could not be deleted, remove it manually or with destroy-cluster\n", sshKey.Name)
+		return err
+	}
+	return nil
+}
+
+// preflightServerType ensures that the server type exists, runs the amd64
+// Talos image if required and is offered in all locations. Server types that
+// are currently sold out only cause a warning.
+func preflightServerType(cl *cluster.Cluster, name string, locations []string, requireX86 bool) error {
+	serverType, _, err := cl.Client.ServerType.Get(*cl.Ctx, name)
+	if err != nil {
+		return err
+	}
+	if serverType == nil {
+		return fmt.Errorf("server type %q could not be found", name)
+	}
+	if requireX86 && serverType.Architecture != hcloud.ArchitectureX86 {
+		return fmt.Errorf("server type %q has architecture %s, but only x86 is supported", name, serverType.Architecture)
+	}
+	if serverType.IsDeprecated() {
+		cl.Logger.Warn.Printf("Server type %q is deprecated\n", name)
+	}
+	datacenters, err := cl.Client.Datacenter.All(*cl.Ctx)
+	if err != nil {
+		return err
+	}
+	for _, location := range locations {
+		offered := false
+		for _, pricing := range serverType.Pricings {
+			if pricing.Location != nil && pricing.Location.Name == location {
+				offered = true
+			}
+		}
+		if !offered {
+			return fmt.Errorf("server type %q is not offered in location %s", name, location)
+		}
+		available := false
+		for _, datacenter := range datacenters {
+			if datacenter.Location == nil || datacenter.Location.Name != location {
+				continue
+			}
+			for _, availableServerType := range datacenter.ServerTypes.Available {
+				if availableServerType.ID == serverType.ID {
+					available = true
+				}
+			}
+		}
+		if !available {
+			cl.Logger.Warn.Printf("Server type %q is currently not available in location %s\n", name, location)
+		}
+	}
+	return nil
+}
+
+// preflightServerLimit ensures that the configured server limit of the project
+// leaves room for the new servers, as hcloud does not expose project limits.
+func preflightServerLimit(cl *cluster.Cluster, nodeCount int) error {
+	if cl.Config.Hcloud.ServerLimit == 0 {
+		cl.Logger.Debug.Printf("No server limit configured, skipping check\n")
+		return nil
+	}
+	servers, err := cl.Client.Server.All(*cl.Ctx)
+	if err != nil {
+		return err
+	}
+	if len(servers)+nodeCount > cl.Config.Hcloud.ServerLimit {
+		return fmt.Errorf("project has %d servers, %d more would exceed the server limit of %d", len(servers), nodeCount, cl.Config.Hcloud.ServerLimit)
+	}
+	return nil
+}
+
+// preflightTalosctlVersion ensures that talosctl is not older than the Talos
+// version, as it generates and applies the machine configs.
+func preflightTalosctlVersion(cl *cluster.Cluster, talosVersion string) error {
+	talosctlVersion, err := TalosClientVersion()
+	if err != nil {
+		return fmt.Errorf("talosctl version could not be determined: %w", err)
+	}
+	talosctlMinor, err := minorVersion(talosctlVersion)
+	if err != nil {
+		return err
+	}
+	talosMinor, err := minorVersion(talosVersion)
+	if err != nil {
+		return err
+	}
+	if compareMinorVersions(talosctlMinor, talosMinor) < 0 {
+		return fmt.Errorf("talosctl %s is older than talos %s", talosctlVersion, talosVersion)
+	}
+	if talosctlMinor != talosMinor {
+		cl.Logger.Warn.Printf("talosctl %s does not match talos %s\n", talosctlVersion, talosVersion)
+	}
+	return nil
+}
+
+// preflightKubernetesVersion ensures that the Kubernetes version is supported
+// by the Talos version. Unknown Talos versions only cause a warning.
+func preflightKubernetesVersion(cl *cluster.Cluster, talosVersion string, kubernetesVersion string) error {
+	talosMinor, err := minorVersion(talosVersion)
+	if err != nil {
+		return err
+	}
+	kubernetesMinor, err := minorVersion(kubernetesVersion)
+	if err != nil {
+		return err
+	}
+	supported, ok := talosKubernetesVersions[talosMinor]
+	if !ok {
+		cl.Logger.Warn.Printf("Supported kubernetes versions of talos %s are unknown\n", talosVersion)
+		return nil
+	}
+	if compareMinorVersions(kubernetesMinor, supported[0]) < 0 || compareMinorVersions(kubernetesMinor, supported[1]) > 0 {
+		return fmt.Errorf("talos %s supports kubernetes %s to %s, but not %s", talosVersion, supported[0], supported[1], kubernetesVersion)
+	}
+	return nil
+}
+
+// minorVersion returns the major and minor part of a version like v1.8.4.
+func minorVersion(version string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("version %q is invalid", version)
+	}
+	for _, part := range parts[:2] {
+		if _, err := strconv.Atoi(part); err != nil {
+			return "", fmt.Errorf("version %q is invalid", version)
+		}
+	}
+	return parts[0] + "." + parts[1], nil
+}
+
+func compareMinorVersions(a string, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < 2; i++ {
+		aPart, _ := strconv.Atoi(aParts[i])
+		bPart, _ := strconv.Atoi(bParts[i])
+		if aPart != bPart {
+			return aPart - bPart
+		}
+	}
+	return 0
+}
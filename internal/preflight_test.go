@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+)
+
+func TestMinorVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+		err      bool
+	}{
+		{version: "1.8.4", expected: "1.8"},
+		{version: "v1.8.4", expected: "1.8"},
+		{version: "v1.10", expected: "1.10"},
+		{version: "1.31.0-alpha.1", expected: "1.31"},
+		{version: "", err: true},
+		{version: "v1", err: true},
+		{version: "1.x.0", err: true},
+		{version: "vv1.8.4", err: true},
+		{version: "latest", err: true},
+	}
+	for _, test := range tests {
+		actual, err := minorVersion(test.version)
+		if test.err {
+			if err == nil {
+				t.Errorf("minorVersion(%q) = %q, expected error", test.version, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("minorVersion(%q) failed: %v", test.version, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("minorVersion(%q) = %q, expected %q", test.version, actual, test.expected)
+		}
+	}
+}
+
+func TestCompareMinorVersions(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{a: "1.8", b: "1.8", expected: 0},
+		{a: "1.8", b: "1.9", expected: -1},
+		{a: "1.10", b: "1.9", expected: 1},
+		{a: "1.9", b: "1.10", expected: -1},
+		{a: "2.0", b: "1.31", expected: 1},
+	}
+	for _, test := range tests {
+		actual := compareMinorVersions(test.a, test.b)
+		if sign(actual) != test.expected {
+			t.Errorf("compareMinorVersions(%q, %q) = %d, expected sign %d", test.a, test.b, actual, test.expected)
+		}
+	}
+}
+
+func TestPreflightKubernetesVersion(t *testing.T) {
+	logger := utils.NewLogger(false)
+	cl := &cluster.Cluster{Logger: &logger}
+	tests := []struct {
+		talosVersion      string
+		kubernetesVersion string
+		err               bool
+	}{
+		{talosVersion: "v1.8.4", kubernetesVersion: "1.31.12"},
+		{talosVersion: "v1.8.4", kubernetesVersion: "v1.26.0"},
+		{talosVersion: "v1.8.4", kubernetesVersion: "1.25.0", err: true},
+		{talosVersion: "v1.8.4", kubernetesVersion: "1.32.0", err: true},
+		{talosVersion: "1.9.0", kubernetesVersion: "1.32.0"},
+		{talosVersion: "1.10.0", kubernetesVersion: "1.33.0"},
+		{talosVersion: "1.10.0", kubernetesVersion: "1.27.0", err: true},
+		{talosVersion: "1.11.0", kubernetesVersion: "1.34.1"},
+		// unknown talos versions only cause a warning
+		{talosVersion: "1.99.0", kubernetesVersion: "1.20.0"},
+		{talosVersion: "latest", kubernetesVersion: "1.31.0", err: true},
+		{talosVersion: "1.8.4", kubernetesVersion: "latest", err: true},
+	}
+	for _, test := range tests {
+		err := preflightKubernetesVersion(cl, test.talosVersion, test.kubernetesVersion)
+		if test.err && err == nil {
+			t.Errorf("preflightKubernetesVersion(%q, %q) expected error", test.talosVersion, test.kubernetesVersion)
+		}
+		if !test.err && err != nil {
+			t.Errorf("preflightKubernetesVersion(%q, %q) failed: %v", test.talosVersion, test.kubernetesVersion, err)
+		}
+	}
+}
+
+func TestTalosKubernetesVersions(t *testing.T) {
+	for talosMinor, supported := range talosKubernetesVersions {
+		if _, err := minorVersion(talosMinor); err != nil {
+			t.Errorf("talos version %q is invalid: %v", talosMinor, err)
+		}
+		if compareMinorVersions(supported[0], supported[1]) > 0 {
+			t.Errorf("talos %s supports kubernetes %s to %s, which is an empty range", talosMinor, supported[0], supported[1])
+		}
+	}
+}
+
+func sign(i int) int {
+	if i < 0 {
+		return -1
+	}
+	if i > 0 {
+		return 1
+	}
+	return 0
+}
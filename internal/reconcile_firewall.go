@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+type ReconcileFirewallOpts struct {
+	ConfigFile string
+}
+
+func ReconcileFirewall(logger *utils.Logger, dir string, opts ReconcileFirewallOpts) (result *Result, err error) {
+	start := time.Now()
+	cl := &cluster.Cluster{Dir: dir}
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Reconciling firewall %s\n", cl.Config.ClusterName)
+
+	network, err := ensureNodeNetwork(cl, false)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = reconcileFirewalls(cl, network, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResult(cl, start), nil
+}
+
+// reconcileFirewalls brings the node firewall and the firewalls for rules
+// scoped to roles or pools in line with the cluster config. Without update
+// differences are only reported. Clusters bootstrapped without firewall are
+// left untouched. It returns the number of differences left.
+func reconcileFirewalls(cl *cluster.Cluster, network *hcloud.Network, update bool) (int, error) {
+	for _, rule := range cl.Config.Firewall.Rules {
+		if err := rule.Validate(); err != nil {
+			return 0, err
+		}
+	}
+
+	drifts := 0
+	firewall, _, err := cl.Client.Firewall.Get(*cl.Ctx, nodeFirewallName(cl))
+	if err != nil {
+		return drifts, err
+	}
+	if firewall == nil {
+		cl.Logger.Debug.Printf("Cluster has no firewall\n")
+		return 0, nil
+	}
+
+	tmpl, err := nodeFirewallTemplate(cl, network)
+	if err != nil {
+		return drifts, err
+	}
+	_, firewallDrifts, err := clients.HcloudEnsureFirewall(cl, tmpl, false, update)
+	drifts += firewallDrifts
+	if err != nil {
+		return drifts, err
+	}
+
+	scopedFirewallNames := map[string]bool{}
+	for _, tmpl := range scopedFirewallTemplates(cl) {
+		scopedFirewallNames[tmpl.Name] = true
+		_, firewallDrifts, err := clients.HcloudEnsureFirewall(cl, tmpl, update, update)
+		drifts += firewallDrifts
+		if err != nil {
+			return drifts, err
+		}
+	}
+
+	scopedFirewalls, err := cl.Client.Firewall.AllWithOpts(*cl.Ctx, hcloud.FirewallListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + firewallLabel + "=scoped",
+		},
+	})
+	if err != nil {
+		return drifts, err
+	}
+	for _, scopedFirewall := range scopedFirewalls {
+		if scopedFirewallNames[scopedFirewall.Name] {
+			continue
+		}
+		if !update {
+			cl.Logger.Warn.Printf("Drift of firewall %q: not needed anymore\n", scopedFirewall.Name)
+			drifts++
+			continue
+		}
+		cl.Logger.Info.Printf("Deleting firewall %q\n", scopedFirewall.Name)
+		if len(scopedFirewall.AppliedTo) > 0 {
+			actions, _, err := cl.Client.Firewall.RemoveResources(*cl.Ctx, scopedFirewall, scopedFirewall.AppliedTo)
+			if err != nil {
+				return drifts, err
+			}
+			err = cl.Client.Action.WaitFor(*cl.Ctx, actions...)
+			if err != nil {
+				return drifts, err
+			}
+		}
+		err = utils.Retry(cl.Logger, func() error {
+			_, err := cl.Client.Firewall.Delete(*cl.Ctx, scopedFirewall)
+			return err
+		})
+		if err != nil {
+			return drifts, err
+		}
+		cl.RecordDeleted("firewall", scopedFirewall.Name, scopedFirewall.ID)
+	}
+
+	return drifts, nil
+}
@@ -2,6 +2,8 @@ package internal
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/airfocusio/hcloud-talos/internal/cluster"
 	"github.com/airfocusio/hcloud-talos/internal/utils"
@@ -15,50 +17,90 @@ type ReconcilePoolOpts struct {
 	NodeCount      int
 	ServerType     string
 	TalosVersion   string
+	NoPreflight    bool
 }
 
-func ReconcilePool(logger *utils.Logger, dir string, opts ReconcilePoolOpts) error {
+func ReconcilePool(logger *utils.Logger, dir string, opts ReconcilePoolOpts) (result *Result, err error) {
+	start := time.Now()
 	cl := &cluster.Cluster{Dir: dir}
-	err := cl.Load(opts.ConfigFile, logger)
+	addNodeResults := []*Result{}
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+			for _, addNodeResult := range addNodeResults {
+				result.merge(addNodeResult)
+			}
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logger.Info.Printf("Reconciling pool %s/%s\n", cl.Config.ClusterName, opts.PoolName)
 	if opts.PoolName == "" {
-		return fmt.Errorf("pool name must not be empty")
+		return nil, fmt.Errorf("pool name must not be empty")
 	}
 	if opts.NodeNamePrefix == "" {
-		return fmt.Errorf("node name prefix must not be empty")
+		return nil, fmt.Errorf("node name prefix must not be empty")
 	}
 	if opts.NodeCount < 0 {
-		return fmt.Errorf("node count must not be negative")
+		return nil, fmt.Errorf("node count must not be negative")
 	}
 	if opts.ServerType == "" {
-		return fmt.Errorf("node server type must not be empty")
+		return nil, fmt.Errorf("node server type must not be empty")
+	}
+	if err := cl.Config.FindPool(opts.PoolName).Validate(); err != nil {
+		return nil, err
+	}
+
+	if !opts.NoPreflight {
+		poolServers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+			ListOpts: hcloud.ListOpts{
+				LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=worker," + poolLabel + "=" + opts.PoolName,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(poolServers) < opts.NodeCount {
+			err := preflight(cl, preflightChecks{
+				ServerType:   opts.ServerType,
+				Pool:         cl.Config.FindPool(opts.PoolName),
+				NodeCount:    opts.NodeCount - len(poolServers),
+				TalosVersion: opts.TalosVersion,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	for {
-		poolServers, _, err := cl.Client.Server.List(*cl.Ctx, hcloud.ServerListOpts{
+		poolServers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
 			ListOpts: hcloud.ListOpts{
 				LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=worker," + poolLabel + "=" + opts.PoolName,
 			},
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		nodeCountDiff := len(poolServers) - opts.NodeCount
 		if nodeCountDiff < 0 {
 			nodeName := opts.NodeNamePrefix + "-%id%"
-			_, err = AddNode(logger, cl.Dir, AddNodeOpts{
+			addNodeResult, err := AddNode(logger, cl.Dir, AddNodeOpts{
 				ConfigFile:   opts.ConfigFile,
 				ServerType:   opts.ServerType,
 				NodeName:     nodeName,
 				PoolName:     opts.PoolName,
 				TalosVersion: opts.TalosVersion,
+				NoPreflight:  true,
 			})
+			if addNodeResult != nil {
+				addNodeResults = append(addNodeResults, addNodeResult)
+			}
 			if err != nil {
-				return err
+				return nil, err
 			}
 		} else {
 			logger.Debug.Printf("Pool %s/%s already has %d of %d nodes\n", cl.Config.ClusterName, opts.PoolName, len(poolServers), opts.NodeCount)
@@ -66,5 +108,42 @@ func ReconcilePool(logger *utils.Logger, dir string, opts ReconcilePoolOpts) err
 		}
 	}
 
+	err = logWorkerPlacementGroups(cl, opts.PoolName)
+	if err != nil {
+		return nil, err
+	}
+
+	result = newResult(cl, start)
+	for _, addNodeResult := range addNodeResults {
+		result.merge(addNodeResult)
+	}
+	return result, nil
+}
+
+// logWorkerPlacementGroups shows how the nodes of the pool are spread over
+// its placement groups. Only new nodes are balanced, by joining the group
+// with the fewest servers; existing servers are never moved, as hcloud only
+// changes the placement group of stopped servers.
+func logWorkerPlacementGroups(cl *cluster.Cluster, pool string) error {
+	selector := clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=worker"
+	if pool != "" {
+		selector = selector + "," + poolLabel + "=" + pool
+	} else {
+		selector = selector + ",!" + poolLabel
+	}
+	placementGroups, err := cl.Client.PlacementGroup.AllWithOpts(*cl.Ctx, hcloud.PlacementGroupListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: selector,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(placementGroups, func(i, j int) bool {
+		return placementGroups[i].Name < placementGroups[j].Name
+	})
+	for _, placementGroup := range placementGroups {
+		cl.Logger.Info.Printf("Placement group %q has %d of %d servers\n", placementGroup.Name, len(placementGroup.Servers), placementGroupSpreadLimit)
+	}
 	return nil
 }
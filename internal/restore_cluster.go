@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+type RestoreClusterOpts struct {
+	ConfigFile      string
+	SnapshotFile    string
+	SkipHashCheck   bool
+	NodeName        string
+	ServerType      string
+	TalosVersion    string
+	Force           bool
+	S3              EtcdBackupS3Opts
+	SkipUnreachable bool
+}
+
+func RestoreCluster(logger *utils.Logger, dir string, opts RestoreClusterOpts) (result *Result, err error) {
+	start := time.Now()
+	cl := &cluster.Cluster{Dir: dir}
+	var controlplaneServer *hcloud.Server
+	defer func() {
+		if err != nil && result == nil {
+			result = newResult(cl, start)
+			if controlplaneServer != nil {
+				result.addNode(controlplaneServer, time.Time{})
+			}
+		}
+	}()
+	err = cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Restoring cluster %s from snapshot %s\n", cl.Config.ClusterName, opts.SnapshotFile)
+	if opts.SnapshotFile == "" {
+		return nil, fmt.Errorf("snapshot file must not be empty")
+	}
+	if opts.NodeName == "" {
+		return nil, fmt.Errorf("node name must not be empty")
+	}
+	if opts.ServerType == "" {
+		return nil, fmt.Errorf("node server type must not be empty")
+	}
+	if opts.TalosVersion == "" {
+		return nil, fmt.Errorf("talos version must not be empty")
+	}
+	if !opts.Force {
+		return nil, fmt.Errorf("restoring the cluster must be forced")
+	}
+	if err := validatePool(cl, cl.Config.Controlplane); err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "hcloud-talos-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	snapshotFile, err := etcdSnapshotForRestore(cl, opts.SnapshotFile, opts.S3, tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	network, err := ensureNodeNetwork(cl, false)
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = ensureControlplaneEndpoint(cl, network, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	remainingServers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	remainingServers, err = reachableRemainingServers(cl, remainingServers, opts.SkipUnreachable)
+	if err != nil {
+		return nil, err
+	}
+
+	controlplaneServer, err = createNodeServer(cl, network, opts.ServerType, true, "", opts.NodeName, opts.TalosVersion)
+	if err != nil {
+		return nil, err
+	}
+	controlplaneServerPrivateIP := controlplaneServer.PrivateNet[0].IP
+
+	_, err = reconcileFirewalls(cl, network, true)
+	if err != nil {
+		return nil, err
+	}
+	err = waitNodeFirewallApplied(cl, controlplaneServer)
+	if err != nil {
+		return nil, err
+	}
+
+	// remaining controlplanes must drop their etcd data to be able to join the
+	// restored etcd cluster, a partially reset etcd quorum must not be restored
+	for _, server := range remainingServers {
+		if server.Labels[roleLabel] != "controlplane" {
+			continue
+		}
+		if len(server.PrivateNet) == 0 || server.PrivateNet[0].IP.Equal(net.IP{}) {
+			return nil, fmt.Errorf("controlplane %s private IP could not be determined", server.Name)
+		}
+		logger.Info.Printf("Resetting etcd data of controlplane %s\n", server.Name)
+		_, err := TalosResetEphemeral(cl, server.PrivateNet[0].IP)
+		if err != nil {
+			return nil, fmt.Errorf("controlplane %s could not be reset: %w", server.Name, err)
+		}
+	}
+
+	// the remaining controlplanes are waiting to rejoin, so the restored one
+	// must hold the floating IP
+	if cl.Config.IsFloatingIPEndpoint() {
+		floatingIP, err := clients.HcloudEnsureFloatingIP(cl, controlplaneFloatingIPTemplate(cl), false)
+		if err != nil {
+			return nil, err
+		}
+		err = clients.HcloudAssignFloatingIP(cl, floatingIP, controlplaneServer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = utils.RetrySlow(logger, func() error {
+		_, err := TalosBootstrapRecover(cl, controlplaneServerPrivateIP, snapshotFile, opts.SkipHashCheck)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = utils.Retry(logger, func() error {
+		_, err := TalosKubeconfig(cl, controlplaneServerPrivateIP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = clients.KubernetesWaitNodeRegistered(cl, controlplaneServer.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, server := range remainingServers {
+		logger.Info.Printf("Waiting for node %s to rejoin\n", server.Name)
+		err := clients.KubernetesWaitNodeReady(cl, server.Name)
+		if err != nil {
+			logger.Warn.Printf("Node %s did not rejoin: %v\n", server.Name, err)
+		}
+	}
+
+	result = newResult(cl, start)
+	result.addNode(controlplaneServer, time.Time{})
+	return result, nil
+}
+
+// reachableRemainingServers checks that the remaining controlplanes can be
+// reset before anything is created, as the restore cannot continue without
+// them. Unreachable controlplanes are left out if they are to be skipped.
+func reachableRemainingServers(cl *cluster.Cluster, servers []*hcloud.Server, skipUnreachable bool) ([]*hcloud.Server, error) {
+	result := []*hcloud.Server{}
+	for _, server := range servers {
+		if server.Labels[roleLabel] != "controlplane" {
+			result = append(result, server)
+			continue
+		}
+		err := fmt.Errorf("controlplane %s private IP could not be determined", server.Name)
+		if len(server.PrivateNet) > 0 && !server.PrivateNet[0].IP.Equal(net.IP{}) {
+			_, err = TalosServerVersion(cl, server.PrivateNet[0].IP)
+		}
+		if err != nil {
+			if !skipUnreachable {
+				return nil, fmt.Errorf("controlplane %s is not reachable, remove it with delete-node or skip it: %w", server.Name, err)
+			}
+			cl.Logger.Warn.Printf("Skipping unreachable controlplane %s, remove it with delete-node\n", server.Name)
+			continue
+		}
+		result = append(result, server)
+	}
+	return result, nil
+}
+
+// etcdSnapshotForRestore returns the local path of the snapshot, after
+// downloading it from S3 if a bucket is given, and verifies it against the
+// checksum file written by etcd-backup.
+func etcdSnapshotForRestore(cl *cluster.Cluster, name string, s3 EtcdBackupS3Opts, tempDir string) (string, error) {
+	snapshotFile := name
+	if s3.Bucket != "" {
+		storage, err := newEtcdBackupStorage(cl, "", s3)
+		if err != nil {
+			return "", err
+		}
+		snapshotFile = path.Join(tempDir, path.Base(name))
+		cl.Logger.Info.Printf("Downloading snapshot %s from bucket %s\n", name, s3.Bucket)
+		err = storage.Get(name, snapshotFile)
+		if err != nil {
+			return "", err
+		}
+		err = storage.Get(name+".sha256", snapshotFile+".sha256")
+		if err != nil {
+			return "", fmt.Errorf("checksum file of snapshot could not be downloaded: %w", err)
+		}
+	} else if !path.IsAbs(snapshotFile) {
+		snapshotFile = path.Join(cl.Dir, snapshotFile)
+	}
+	if _, err := os.Stat(snapshotFile); err != nil {
+		return "", err
+	}
+	err := verifyEtcdSnapshotChecksum(snapshotFile, snapshotFile+".sha256")
+	if err != nil {
+		return "", err
+	}
+	return snapshotFile, nil
+}
+
+// waitNodeFirewallApplied ensures that the existing node firewall protects the
+// new server, as it is only applied through its label selector.
+func waitNodeFirewallApplied(cl *cluster.Cluster, server *hcloud.Server) error {
+	firewall, _, err := cl.Client.Firewall.Get(*cl.Ctx, nodeFirewallName(cl))
+	if err != nil {
+		return err
+	}
+	if firewall == nil {
+		cl.Logger.Debug.Printf("Cluster has no firewall\n")
+		return nil
+	}
+	// firewalls only apply to public interfaces
+	if server.PublicNet.IPv4.IsUnspecified() && server.PublicNet.IPv6.IsUnspecified() {
+		return nil
+	}
+	return utils.Retry(cl.Logger, func() error {
+		server, _, err := cl.Client.Server.GetByID(*cl.Ctx, server.ID)
+		if err != nil {
+			return err
+		}
+		if server == nil {
+			return fmt.Errorf("server could not be found")
+		}
+		for _, status := range server.PublicNet.Firewalls {
+			if status.Firewall.ID == firewall.ID && status.Status == hcloud.FirewallStatusApplied {
+				return nil
+			}
+		}
+		return fmt.Errorf("firewall %q is not yet applied to server %q", firewall.Name, server.Name)
+	})
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// Result describes what a command changed, so that scripts can learn e.g.
+// the generated name and IPs of a new node without parsing the logs.
+type Result struct {
+	Created         []cluster.Resource `json:"created" yaml:"created"`
+	Deleted         []cluster.Resource `json:"deleted" yaml:"deleted"`
+	Nodes           []ResultNode       `json:"nodes" yaml:"nodes"`
+	DurationSeconds float64            `json:"durationSeconds" yaml:"durationSeconds"`
+	Updated         []cluster.Resource `json:"updated" yaml:"updated"`
+}
+
+type ResultNode struct {
+	Name            string  `json:"name" yaml:"name"`
+	Role            string  `json:"role" yaml:"role"`
+	Pool            string  `json:"pool,omitempty" yaml:"pool,omitempty"`
+	ServerID        int     `json:"serverId" yaml:"serverId"`
+	PublicIPv4      string  `json:"publicIPv4,omitempty" yaml:"publicIPv4,omitempty"`
+	PublicIPv6      string  `json:"publicIPv6,omitempty" yaml:"publicIPv6,omitempty"`
+	PrivateIP       string  `json:"privateIP,omitempty" yaml:"privateIP,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds,omitempty" yaml:"durationSeconds,omitempty"`
+}
+
+func newResult(cl *cluster.Cluster, start time.Time) *Result {
+	result := &Result{
+		Created:         append([]cluster.Resource{}, cl.Created...),
+		Deleted:         append([]cluster.Resource{}, cl.Deleted...),
+		Nodes:           []ResultNode{},
+		DurationSeconds: time.Since(start).Seconds(),
+		Updated:         append([]cluster.Resource{}, cl.Updated...),
+	}
+	return result
+}
+
+// addNode adds the node of the server, with the time it took since start if
+// not zero.
+func (r *Result) addNode(server *hcloud.Server, start time.Time) {
+	publicIPv4, publicIPv6, privateIP := serverIPs(server)
+	node := ResultNode{
+		Name:       server.Name,
+		Role:       server.Labels[roleLabel],
+		Pool:       server.Labels[poolLabel],
+		ServerID:   server.ID,
+		PublicIPv4: publicIPv4,
+		PublicIPv6: publicIPv6,
+		PrivateIP:  privateIP,
+	}
+	if !start.IsZero() {
+		node.DurationSeconds = time.Since(start).Seconds()
+	}
+	r.Nodes = append(r.Nodes, node)
+}
+
+// merge adds the changes of a nested command.
+func (r *Result) merge(other *Result) {
+	r.Created = append(r.Created, other.Created...)
+	r.Deleted = append(r.Deleted, other.Deleted...)
+	r.Nodes = append(r.Nodes, other.Nodes...)
+	r.Updated = append(r.Updated, other.Updated...)
+}
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/airfocusio/hcloud-talos/internal/clients"
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+	"github.com/airfocusio/hcloud-talos/internal/utils"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	v1 "k8s.io/api/core/v1"
+)
+
+type NodeStatus struct {
+	Name              string `json:"name" yaml:"name"`
+	Role              string `json:"role" yaml:"role"`
+	Pool              string `json:"pool,omitempty" yaml:"pool,omitempty"`
+	ServerID          int    `json:"serverId" yaml:"serverId"`
+	ServerType        string `json:"serverType" yaml:"serverType"`
+	ServerStatus      string `json:"serverStatus" yaml:"serverStatus"`
+	Location          string `json:"location" yaml:"location"`
+	PublicIPv4        string `json:"publicIPv4,omitempty" yaml:"publicIPv4,omitempty"`
+	PublicIPv6        string `json:"publicIPv6,omitempty" yaml:"publicIPv6,omitempty"`
+	PrivateIP         string `json:"privateIP,omitempty" yaml:"privateIP,omitempty"`
+	Registered        bool   `json:"registered" yaml:"registered"`
+	Ready             bool   `json:"ready" yaml:"ready"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty" yaml:"kubernetesVersion,omitempty"`
+	TalosVersion      string `json:"talosVersion,omitempty" yaml:"talosVersion,omitempty"`
+}
+
+type PoolStatus struct {
+	Name  string `json:"name" yaml:"name"`
+	Role  string `json:"role" yaml:"role"`
+	Nodes int    `json:"nodes" yaml:"nodes"`
+	Ready int    `json:"ready" yaml:"ready"`
+}
+
+type ClusterStatus struct {
+	ClusterName          string       `json:"clusterName" yaml:"clusterName"`
+	ControlplaneEndpoint string       `json:"controlplaneEndpoint" yaml:"controlplaneEndpoint"`
+	ControlplaneIP       string       `json:"controlplaneIP,omitempty" yaml:"controlplaneIP,omitempty"`
+	Pools                []PoolStatus `json:"pools" yaml:"pools"`
+	Nodes                []NodeStatus `json:"nodes" yaml:"nodes"`
+}
+
+type ListNodesOpts struct {
+	ConfigFile string
+}
+
+func ListNodes(logger *utils.Logger, dir string, opts ListNodesOpts) ([]NodeStatus, error) {
+	cl := &cluster.Cluster{Dir: dir}
+	err := cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	return listNodes(cl)
+}
+
+type StatusOpts struct {
+	ConfigFile string
+}
+
+func Status(logger *utils.Logger, dir string, opts StatusOpts) (*ClusterStatus, error) {
+	cl := &cluster.Cluster{Dir: dir}
+	err := cl.Load(opts.ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ClusterStatus{
+		ClusterName:          cl.Config.ClusterName,
+		ControlplaneEndpoint: cl.Config.ControlplaneEndpoint,
+		Pools:                []PoolStatus{},
+	}
+	if status.ControlplaneEndpoint == "" {
+		status.ControlplaneEndpoint = "load-balancer"
+	}
+	network, err := ensureNodeNetwork(cl, false)
+	if err != nil {
+		return nil, err
+	}
+	controlplaneIP, _, err := ensureControlplaneEndpoint(cl, network, false, false)
+	if err != nil {
+		logger.Warn.Printf("Controlplane endpoint could not be determined: %v\n", err)
+	} else {
+		status.ControlplaneIP = controlplaneIP.String()
+	}
+
+	nodes, err := listNodes(cl)
+	if err != nil {
+		return nil, err
+	}
+	status.Nodes = nodes
+	pools := map[string]*PoolStatus{}
+	for _, node := range nodes {
+		key := node.Role + "/" + node.Pool
+		pool, ok := pools[key]
+		if !ok {
+			pool = &PoolStatus{Name: node.Pool, Role: node.Role}
+			pools[key] = pool
+		}
+		pool.Nodes++
+		if node.Ready {
+			pool.Ready++
+		}
+	}
+	for _, pool := range pools {
+		status.Pools = append(status.Pools, *pool)
+	}
+	sort.Slice(status.Pools, func(i, j int) bool {
+		if status.Pools[i].Role != status.Pools[j].Role {
+			return status.Pools[i].Role == "controlplane"
+		}
+		return status.Pools[i].Name < status.Pools[j].Name
+	})
+	return status, nil
+}
+
+// listNodes joins the node servers of the cluster with their Kubernetes
+// nodes. If the Kubernetes API is not reachable, only the server part is
+// returned.
+func listNodes(cl *cluster.Cluster) ([]NodeStatus, error) {
+	servers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	kubernetesNodes := map[string]v1.Node{}
+	nodes, err := clients.KubernetesListNodes(cl)
+	if err != nil {
+		cl.Logger.Warn.Printf("Kubernetes nodes could not be listed: %v\n", err)
+	}
+	for _, node := range nodes {
+		kubernetesNodes[node.Name] = node
+	}
+
+	result := []NodeStatus{}
+	for _, server := range servers {
+		status := NodeStatus{
+			Name:         server.Name,
+			Role:         server.Labels[roleLabel],
+			Pool:         server.Labels[poolLabel],
+			ServerID:     server.ID,
+			ServerStatus: string(server.Status),
+		}
+		if server.ServerType != nil {
+			status.ServerType = server.ServerType.Name
+		}
+		if server.Datacenter != nil && server.Datacenter.Location != nil {
+			status.Location = server.Datacenter.Location.Name
+		}
+		status.PublicIPv4, status.PublicIPv6, status.PrivateIP = serverIPs(server)
+		if node, ok := kubernetesNodes[server.Name]; ok {
+			status.Registered = true
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == v1.NodeReady {
+					status.Ready = condition.Status == v1.ConditionTrue
+				}
+			}
+			status.KubernetesVersion = node.Status.NodeInfo.KubeletVersion
+			status.TalosVersion = talosVersionFromOSImage(node.Status.NodeInfo.OSImage)
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Role != result[j].Role {
+			return result[i].Role == "controlplane"
+		}
+		if result[i].Pool != result[j].Pool {
+			return result[i].Pool < result[j].Pool
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// serverIPs returns the public IPs and the private IP of the server, or empty
+// strings for those it does not have.
+func serverIPs(server *hcloud.Server) (string, string, string) {
+	publicIPv4, publicIPv6, privateIP := "", "", ""
+	if !server.PublicNet.IPv4.IsUnspecified() {
+		publicIPv4 = server.PublicNet.IPv4.IP.String()
+	}
+	if !server.PublicNet.IPv6.IsUnspecified() {
+		publicIPv6 = server.PublicNet.IPv6.IP.String()
+	}
+	if len(server.PrivateNet) > 0 {
+		privateIP = server.PrivateNet[0].IP.String()
+	}
+	return publicIPv4, publicIPv6, privateIP
+}
+
+// talosVersionFromOSImage extracts the version from an OS image like
+// "Talos (v1.8.4)", as reported by the kubelet.
+func talosVersionFromOSImage(osImage string) string {
+	start := strings.Index(osImage, "(")
+	end := strings.LastIndex(osImage, ")")
+	if !strings.HasPrefix(osImage, "Talos") || start < 0 || end < start {
+		return ""
+	}
+	return osImage[start+1 : end]
+}
@@ -0,0 +1,22 @@
+package internal
+
+import "testing"
+
+func TestTalosVersionFromOSImage(t *testing.T) {
+	tests := []struct {
+		osImage  string
+		expected string
+	}{
+		{osImage: "Talos (v1.8.4)", expected: "v1.8.4"},
+		{osImage: "Talos (v1.9.0-alpha.1)", expected: "v1.9.0-alpha.1"},
+		{osImage: "Talos", expected: ""},
+		{osImage: "Talos )v1.8.4(", expected: ""},
+		{osImage: "Debian GNU/Linux 12 (bookworm)", expected: ""},
+		{osImage: "", expected: ""},
+	}
+	for _, test := range tests {
+		if actual := talosVersionFromOSImage(test.osImage); actual != test.expected {
+			t.Errorf("talosVersionFromOSImage(%q) = %q, expected %q", test.osImage, actual, test.expected)
+		}
+	}
+}
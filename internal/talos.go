@@ -1,11 +1,14 @@
 package internal
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"fmt"
 	"net"
+	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,12 +16,17 @@ import (
 	"github.com/airfocusio/hcloud-talos/internal/cluster"
 	"github.com/airfocusio/hcloud-talos/internal/utils"
 	"github.com/hetznercloud/hcloud-go/hcloud"
+	"gopkg.in/yaml.v3"
 	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 )
 
 var TalosctlBin = "talosctl"
 
+// every server gets a public IPv6 /64 out of the global unicast range, which
+// lets the kubelet pick it as its IPv6 node IP on dual-stack clusters
+const talosPublicIPv6Range = "2000::/3"
+
 func TalosClientVersion() (string, error) {
 	output, err := talosctlCmdRaw(".", "version", "--client", "--short")
 	if err != nil {
@@ -28,7 +36,7 @@ func TalosClientVersion() (string, error) {
 	return version, nil
 }
 
-func TalosGenConfig(cl *cluster.Cluster, network *hcloud.Network, clusterName string, controlplaneIP net.IP, kubernetesVersion string, withKubespan bool) (string, error) {
+func TalosGenConfig(cl *cluster.Cluster, network *hcloud.Network, clusterName string, controlplaneIP net.IP, additionalSANs []net.IP, kubernetesVersion string, withKubespan bool) (string, error) {
 	configPatch, err := utils.RenderTemplate(`
 		[
 			{
@@ -36,7 +44,13 @@ func TalosGenConfig(cl *cluster.Cluster, network *hcloud.Network, clusterName st
 				"path": "/machine/kubelet/nodeIP",
 				"value": {
 					"validSubnets": [
-						"{{ .NetworkIPRange }}"
+						{{- range $i, $nodeSubnet := .NodeSubnets }}
+						{{- if $i }},{{ end }}
+						"{{ $nodeSubnet }}"
+						{{- end }}
+						{{- if .DualStack }},
+						"{{ .PublicIPv6Range }}"
+						{{- end }}
 					]
 				}
 			},
@@ -48,6 +62,57 @@ func TalosGenConfig(cl *cluster.Cluster, network *hcloud.Network, clusterName st
 					"manifests": []
 				}
 			},
+			{
+				"op": "add",
+				"path": "/cluster/network/podSubnets",
+				"value": [
+					{{- range $i, $podSubnet := .PodSubnets }}
+					{{- if $i }},{{ end }}
+					"{{ $podSubnet }}"
+					{{- end }}
+				]
+			},
+			{
+				"op": "add",
+				"path": "/cluster/network/serviceSubnets",
+				"value": [
+					{{- range $i, $serviceSubnet := .ServiceSubnets }}
+					{{- if $i }},{{ end }}
+					"{{ $serviceSubnet }}"
+					{{- end }}
+				]
+			},
+			{{- if .Cilium }}
+			{
+				"op": "add",
+				"path": "/cluster/network/cni",
+				"value": {
+					"name": "none"
+				}
+			},
+			{{- else }}
+			{
+				"op": "add",
+				"path": "/cluster/network/cni",
+				"value": {
+					"name": "flannel",
+					"flannel": {
+						"extraArgs": [
+							"{{ .FlannelIfaceArg }}"
+						]
+					}
+				}
+			},
+			{{- end }}
+			{{- if .KubeProxyReplacement }}
+			{
+				"op": "add",
+				"path": "/cluster/proxy",
+				"value": {
+					"disabled": true
+				}
+			},
+			{{- end }}
 			{
 				"op": "add",
 				"path": "/machine/systemDiskEncryption",
@@ -64,16 +129,27 @@ func TalosGenConfig(cl *cluster.Cluster, network *hcloud.Network, clusterName st
 				}
 			}
 		]
-	`, map[string]string{
-		"NetworkIPRange": network.IPRange.String(),
+	`, map[string]interface{}{
+		"NodeSubnets":          cl.Config.Network.NodeSubnets,
+		"Cilium":               cl.Config.Cni.IsCilium(),
+		"FlannelIfaceArg":      talosFlannelIfaceArg(cl),
+		"PodSubnets":           cl.Config.Network.PodSubnets(),
+		"ServiceSubnets":       cl.Config.Network.ServiceSubnets(),
+		"DualStack":            cl.Config.Network.DualStack,
+		"PublicIPv6Range":      talosPublicIPv6Range,
+		"KubeProxyReplacement": cl.Config.Cni.KubeProxyReplacement,
 	})
 	if err != nil {
 		return "", err
 	}
+	sans := []string{controlplaneIP.String()}
+	for _, san := range additionalSANs {
+		sans = append(sans, san.String())
+	}
 	args := []string{
 		"gen", "config",
 		clusterName, fmt.Sprintf("https://%s:6443", controlplaneIP.String()),
-		"--additional-sans", controlplaneIP.String(),
+		"--additional-sans", strings.Join(sans, ","),
 		"--config-patch", configPatch,
 		"--kubernetes-version", kubernetesVersion,
 		"--with-examples=false",
@@ -93,18 +169,209 @@ func TalosGenConfig(cl *cluster.Cluster, network *hcloud.Network, clusterName st
 	return output1 + output2, nil
 }
 
+// talosFlannelIfaceArg makes flannel use the private network interface,
+// otherwise pod traffic is sent over the public network and dropped by the
+// firewall. The interface is chosen by its route to the network gateway, as
+// its name depends on whether the node has a public interface.
+func talosFlannelIfaceArg(cl *cluster.Cluster) string {
+	return "--iface-can-reach=" + cl.Config.Network.Gateway().String()
+}
+
 func TalosBootstrap(cl *cluster.Cluster, serverIP net.IP) (string, error) {
 	return talosctlCmd(cl, "-n", serverIP.String(), "bootstrap")
 }
 
+func TalosBootstrapRecover(cl *cluster.Cluster, serverIP net.IP, snapshotFile string, skipHashCheck bool) (string, error) {
+	args := []string{"-n", serverIP.String(), "bootstrap", "--recover-from", snapshotFile}
+	if skipHashCheck {
+		args = append(args, "--recover-skip-hash-check")
+	}
+	return talosctlCmdTimeout(cl, talosctlTransferTimeout, args...)
+}
+
 func TalosKubeconfig(cl *cluster.Cluster, serverIP net.IP) (string, error) {
 	return talosctlCmd(cl, "-n", serverIP.String(), "kubeconfig", ".")
 }
 
+func TalosApplyConfig(cl *cluster.Cluster, serverIP net.IP, configFile string, mode string, dryRun bool) (string, error) {
+	args := []string{"-n", serverIP.String(), "apply-config", "--file", configFile, "--mode", mode}
+	if dryRun {
+		args = append(args, "--dry-run")
+	}
+	return talosctlCmd(cl, args...)
+}
+
+func TalosHealth(cl *cluster.Cluster, serverIP net.IP) (string, error) {
+	return talosctlCmd(cl, "-n", serverIP.String(), "health", "--wait-timeout", "50s")
+}
+
+func TalosEtcdStatus(cl *cluster.Cluster, serverIP net.IP) (string, error) {
+	return talosctlCmd(cl, "-n", serverIP.String(), "etcd", "status")
+}
+
+// TalosEtcdMembers returns the hostnames of the etcd members.
+func TalosEtcdMembers(cl *cluster.Cluster, serverIP net.IP) ([]string, error) {
+	output, err := talosctlCmdStdout(cl, "-n", serverIP.String(), "etcd", "members")
+	if err != nil {
+		return nil, err
+	}
+	return parseTalosEtcdMembers(output)
+}
+
+type TalosService struct {
+	ID     string
+	State  string
+	Health string
+}
+
+func TalosServices(cl *cluster.Cluster, serverIP net.IP) ([]TalosService, error) {
+	output, err := talosctlCmdStdout(cl, "-n", serverIP.String(), "services")
+	if err != nil {
+		return nil, err
+	}
+	return parseTalosServices(output)
+}
+
+// parseTalosEtcdMembers parses the table printed by talosctl etcd members.
+func parseTalosEtcdMembers(output string) ([]string, error) {
+	rows, err := parseTalosTable(output, "HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, row := range rows {
+		result = append(result, row["HOSTNAME"])
+	}
+	return result, nil
+}
+
+// parseTalosServices parses the table printed by talosctl services.
+func parseTalosServices(output string) ([]TalosService, error) {
+	rows, err := parseTalosTable(output, "SERVICE", "STATE", "HEALTH")
+	if err != nil {
+		return nil, err
+	}
+	result := []TalosService{}
+	for _, row := range rows {
+		result = append(result, TalosService{ID: row["SERVICE"], State: row["STATE"], Health: row["HEALTH"]})
+	}
+	return result, nil
+}
+
+// parseTalosTable parses the given columns of a table printed by talosctl.
+// The columns are looked up by their header, so they must come before the
+// first column with a multi-word header or value.
+func parseTalosTable(output string, columns ...string) ([]map[string]string, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	header := strings.Fields(lines[0])
+	indexes := map[string]int{}
+	for _, column := range columns {
+		index := slices.Index(header, column)
+		if index < 0 {
+			return nil, fmt.Errorf("talos output has no column %s", column)
+		}
+		indexes[column] = index
+	}
+	result := []map[string]string{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		row := map[string]string{}
+		for column, index := range indexes {
+			if index >= len(fields) {
+				return nil, fmt.Errorf("talos output line %q has no column %s", line, column)
+			}
+			row[column] = fields[index]
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+func TalosEtcdSnapshot(cl *cluster.Cluster, serverIP net.IP, snapshotFile string) (string, error) {
+	return talosctlCmdTimeout(cl, talosctlTransferTimeout, "-n", serverIP.String(), "etcd", "snapshot", snapshotFile)
+}
+
 func TalosReset(cl *cluster.Cluster, serverIP net.IP) (string, error) {
 	return talosctlCmd(cl, "-n", serverIP.String(), "reset")
 }
 
+func TalosServerVersion(cl *cluster.Cluster, serverIP net.IP) (string, error) {
+	return talosctlCmd(cl, "-n", serverIP.String(), "version", "--short")
+}
+
+func TalosResetEphemeral(cl *cluster.Cluster, serverIP net.IP) (string, error) {
+	return talosctlCmd(cl, "-n", serverIP.String(), "reset", "--graceful=false", "--reboot", "--system-labels-to-wipe", "EPHEMERAL")
+}
+
+func TalosReadConfig(cl *cluster.Cluster, serverIP net.IP) (string, error) {
+	return talosctlCmdStdout(cl, "-n", serverIP.String(), "read", "/system/state/config.yaml")
+}
+
+func TalosPatchConfig(cl *cluster.Cluster, configFile string, patch string) (string, error) {
+	outputFile, err := os.CreateTemp("", "hcloud-talos-*.yaml")
+	if err != nil {
+		return "", err
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	_, err = talosctlCmdRaw(cl.Dir, "machineconfig", "patch", configFile, "--patch", patch, "--output", outputFile.Name())
+	if err != nil {
+		return "", err
+	}
+	output, err := os.ReadFile(outputFile.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+func TalosConfigFlannelExtraArgs(configYaml []byte) ([]string, error) {
+	documents, err := utils.YamlSplit(configYaml)
+	if err != nil {
+		return nil, err
+	}
+	for _, document := range documents {
+		config := struct {
+			Cluster *struct {
+				Network struct {
+					Cni struct {
+						Flannel struct {
+							ExtraArgs []string `yaml:"extraArgs"`
+						} `yaml:"flannel"`
+					} `yaml:"cni"`
+				} `yaml:"network"`
+			} `yaml:"cluster"`
+		}{}
+		err := yaml.Unmarshal(document, &config)
+		if err != nil {
+			return nil, err
+		}
+		if config.Cluster != nil {
+			return config.Cluster.Network.Cni.Flannel.ExtraArgs, nil
+		}
+	}
+	return nil, fmt.Errorf("config does not contain cluster section")
+}
+
+func TalosFlannelDaemonSetArgs(cl *cluster.Cluster) ([]string, error) {
+	kubeClientset, _, err := clients.KubernetesInit(cl)
+	if err != nil {
+		return nil, err
+	}
+	daemonSet, err := kubeClientset.AppsV1().DaemonSets("kube-system").Get(*cl.Ctx, "kube-flannel", k8smetav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(daemonSet.Spec.Template.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("flannel daemon set does not have any containers")
+	}
+	return daemonSet.Spec.Template.Spec.Containers[0].Args, nil
+}
+
 func TalosPatchFlannelDaemonSet(cl *cluster.Cluster, jsonPatch string) error {
 	kubeClientset, _, err := clients.KubernetesInit(cl)
 	if err != nil {
@@ -117,17 +384,49 @@ func TalosPatchFlannelDaemonSet(cl *cluster.Cluster, jsonPatch string) error {
 	return nil
 }
 
+const (
+	talosctlTimeout = time.Minute
+	// talosctlTransferTimeout applies to commands that stream an etcd snapshot
+	// from or to a node, which takes longer for large databases.
+	talosctlTransferTimeout = 30 * time.Minute
+)
+
 func talosctlCmd(cl *cluster.Cluster, args ...string) (string, error) {
+	return talosctlCmdTimeout(cl, talosctlTimeout, args...)
+}
+
+func talosctlCmdTimeout(cl *cluster.Cluster, timeout time.Duration, args ...string) (string, error) {
 	fullArgs := append([]string{"--talosconfig", "talosconfig"}, args...)
-	output, err := talosctlCmdRaw(cl.Dir, fullArgs...)
+	output, err := talosctlCmdRawTimeout(cl.Dir, timeout, fullArgs...)
 	if err != nil {
 		return "", err
 	}
 	return output, nil
 }
 
+// talosctlCmdStdout only returns the standard output of talosctl, so that
+// warnings printed to standard error do not end up in parsed output.
+func talosctlCmdStdout(cl *cluster.Cluster, args ...string) (string, error) {
+	fullArgs := append([]string{"--talosconfig", "talosconfig"}, args...)
+	ctx, cancel := context.WithTimeout(context.Background(), talosctlTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, TalosctlBin, fullArgs...)
+	cmd.Dir = cl.Dir
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return string(output), fmt.Errorf("talos command %s failed: %w\n%s", strings.Join(fullArgs, " "), err, stderr)
+	}
+	return string(output), nil
+}
+
 func talosctlCmdRaw(dir string, args ...string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	return talosctlCmdRawTimeout(dir, talosctlTimeout, args...)
+}
+
+func talosctlCmdRawTimeout(dir string, timeout time.Duration, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, TalosctlBin, args...)
 	cmd.Dir = dir
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/airfocusio/hcloud-talos/internal/cluster"
+)
+
+func TestParseTalosServices(t *testing.T) {
+	output := `NODE       SERVICE      STATE     HEALTH   LAST CHANGE   LAST EVENT
+10.0.0.2   apid         Running   OK       1h2m ago      Health check successful
+10.0.0.2   etcd         Preparing ?        5s ago        Running pre state
+10.0.0.2   kubelet      Running   Fail     3m ago        Health check failed: connection refused
+
+`
+	actual, err := parseTalosServices(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TalosService{
+		{ID: "apid", State: "Running", Health: "OK"},
+		{ID: "etcd", State: "Preparing", Health: "?"},
+		{ID: "kubelet", State: "Running", Health: "Fail"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("parseTalosServices = %v, expected %v", actual, expected)
+	}
+
+	if _, err := parseTalosServices(""); err == nil {
+		t.Errorf("parseTalosServices of empty output expected error")
+	}
+	if _, err := parseTalosServices("NODE SERVICE\n10.0.0.2 apid\n"); err == nil {
+		t.Errorf("parseTalosServices without state column expected error")
+	}
+}
+
+func TestParseTalosEtcdMembers(t *testing.T) {
+	output := `NODE       ID                 HOSTNAME         PEER URLS                CLIENT URLS              LEARNER
+10.0.0.2   2d4a2b1c3e5f6a7b   controlplane-1   https://10.0.0.2:2380   https://10.0.0.2:2379   false
+10.0.0.2   8f9e0d1c2b3a4f5e   controlplane-2   https://10.0.0.3:2380   https://10.0.0.3:2379   false
+`
+	actual, err := parseTalosEtcdMembers(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"controlplane-1", "controlplane-2"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("parseTalosEtcdMembers = %v, expected %v", actual, expected)
+	}
+
+	// without node column, as printed for a single node by older talosctl
+	actual, err = parseTalosEtcdMembers("ID   HOSTNAME   PEER URLS\n2d4a2b1c3e5f6a7b   controlplane-1   https://10.0.0.2:2380\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, []string{"controlplane-1"}) {
+		t.Errorf("parseTalosEtcdMembers without node column = %v", actual)
+	}
+
+	if _, err := parseTalosEtcdMembers("NODE   ID   HOSTNAME\n10.0.0.2   2d4a2b1c3e5f6a7b\n"); err == nil {
+		t.Errorf("parseTalosEtcdMembers of truncated line expected error")
+	}
+}
+
+func TestTalosFlannelIfaceArg(t *testing.T) {
+	tests := []struct {
+		ipRange  string
+		expected string
+	}{
+		{ipRange: "10.0.0.0/16", expected: "--iface-can-reach=10.0.0.1"},
+		{ipRange: "172.16.0.0/12", expected: "--iface-can-reach=172.16.0.1"},
+	}
+	for _, test := range tests {
+		cl := &cluster.Cluster{Config: cluster.Config{Network: cluster.ConfigNetwork{IPRange: test.ipRange}}}
+		if actual := talosFlannelIfaceArg(cl); actual != test.expected {
+			t.Errorf("talosFlannelIfaceArg(%q) = %q, expected %q", test.ipRange, actual, test.expected)
+		}
+	}
+}
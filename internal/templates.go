@@ -5,69 +5,266 @@ import (
 	"net"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/airfocusio/hcloud-talos/internal/clients"
 	"github.com/airfocusio/hcloud-talos/internal/cluster"
 	"github.com/airfocusio/hcloud-talos/internal/utils"
 	"github.com/hetznercloud/hcloud-go/hcloud"
+	"gopkg.in/yaml.v3"
 )
 
 const (
-	labelPrefix  = "hct.airfocus.io/"
-	clusterLabel = labelPrefix + "cluster"
-	roleLabel    = labelPrefix + "role"
-	poolLabel    = labelPrefix + "pool"
+	labelPrefix   = "hct.airfocus.io/"
+	clusterLabel  = labelPrefix + "cluster"
+	roleLabel     = labelPrefix + "role"
+	poolLabel     = labelPrefix + "pool"
+	natLabel      = labelPrefix + "nat"
+	bastionLabel  = labelPrefix + "bastion"
+	locationLabel = labelPrefix + "location"
+	shardLabel    = labelPrefix + "shard"
+	regionLabel   = "topology.kubernetes.io/region"
+	firewallLabel = labelPrefix + "firewall"
 )
 
 func nodeNetworkTemplate(cl *cluster.Cluster) hcloud.NetworkCreateOpts {
-	_, privateIPRange, _ := net.ParseCIDR("10.0.0.0/16")
-	_, privateIPRangeSubnet, _ := net.ParseCIDR("10.0.0.0/24")
+	_, privateIPRange, _ := net.ParseCIDR(cl.Config.Network.IPRange)
+	subnets := []hcloud.NetworkSubnet{}
+	for _, nodeSubnet := range cl.Config.Network.NodeSubnets {
+		_, privateIPRangeSubnet, _ := net.ParseCIDR(nodeSubnet)
+		subnets = append(subnets, hcloud.NetworkSubnet{
+			Type:        hcloud.NetworkSubnetTypeCloud,
+			IPRange:     privateIPRangeSubnet,
+			NetworkZone: hcloud.NetworkZone(cl.Config.Hcloud.NetworkZone),
+		})
+	}
+	name := cl.Config.ClusterName + "-nodes"
+	if cl.Config.Network.ExistingNetwork != "" {
+		name = cl.Config.Network.ExistingNetwork
+	}
 	return hcloud.NetworkCreateOpts{
-		Name:    cl.Config.ClusterName + "-nodes",
+		Name:    name,
 		IPRange: privateIPRange,
-		Subnets: []hcloud.NetworkSubnet{
-			{
-				Type:        hcloud.NetworkSubnetTypeCloud,
-				IPRange:     privateIPRangeSubnet,
-				NetworkZone: hcloud.NetworkZone(cl.Config.Hcloud.NetworkZone),
-			},
-		},
-		Labels: map[string]string{clusterLabel: cl.Config.ClusterName},
+		Subnets: subnets,
+		Labels:  map[string]string{clusterLabel: cl.Config.ClusterName},
 	}
 }
 
-func controlplanePlacementGroupTemplate(cl *cluster.Cluster) hcloud.PlacementGroupCreateOpts {
+func ensureNodeNetwork(cl *cluster.Cluster, create bool) (*hcloud.Network, error) {
+	var network *hcloud.Network
+	if cl.Config.Network.ExistingNetwork != "" {
+		existingNetwork, drifts, err := clients.HcloudEnsureNetworkSubnets(cl, nodeNetworkTemplate(cl), create)
+		if err != nil {
+			return nil, err
+		}
+		if drifts > 0 {
+			return nil, fmt.Errorf("existing network %q does not contain all node subnets", existingNetwork.Name)
+		}
+		network = existingNetwork
+	} else {
+		ownNetwork, _, err := clients.HcloudEnsureNetwork(cl, nodeNetworkTemplate(cl), create, false)
+		if err != nil {
+			return nil, err
+		}
+		network = ownNetwork
+	}
+	if err := validateNativePodSubnet(cl, network); err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// validateNativePodSubnet ensures that no subnet of the network overlaps the
+// pod subnet with native pod routing. hcloud has no way to reserve the range
+// for the routes of the pod CIDRs, so subnets added to the network by others
+// would silently break pod traffic.
+func validateNativePodSubnet(cl *cluster.Cluster, network *hcloud.Network) error {
+	if !cl.Config.Network.IsNativePodRouting() {
+		return nil
+	}
+	_, podSubnet, err := net.ParseCIDR(cl.Config.Network.PodSubnet)
+	if err != nil {
+		return err
+	}
+	for _, subnet := range network.Subnets {
+		if subnet.IPRange != nil && (subnet.IPRange.Contains(podSubnet.IP) || podSubnet.Contains(subnet.IPRange.IP)) {
+			return fmt.Errorf("subnet %s of network %q overlaps pod subnet %s", subnet.IPRange, network.Name, podSubnet)
+		}
+	}
+	return nil
+}
+
+// controlplanePlacementGroupTemplate returns the placement group of the
+// controlplanes in the given location. The one of the cluster location keeps
+// its unsuffixed name.
+func controlplanePlacementGroupTemplate(cl *cluster.Cluster, location string) hcloud.PlacementGroupCreateOpts {
+	name := cl.Config.ClusterName + "-controlplanes"
+	if location != cl.Config.Hcloud.Location {
+		name = name + "-" + location
+	}
 	return hcloud.PlacementGroupCreateOpts{
-		Name:   cl.Config.ClusterName + "-controlplanes",
+		Name:   name,
 		Type:   hcloud.PlacementGroupTypeSpread,
 		Labels: map[string]string{clusterLabel: cl.Config.ClusterName},
 	}
 }
 
+// placementGroupSpreadLimit is the maximum number of servers hcloud allows in
+// a spread placement group.
+const placementGroupSpreadLimit = 10
+
+// workerPlacementGroupTemplate returns one of the placement groups (shards) of
+// the workers of a pool in the given location.
+func workerPlacementGroupTemplate(cl *cluster.Cluster, pool string, location string, shard int) hcloud.PlacementGroupCreateOpts {
+	name := cl.Config.ClusterName + "-workers"
+	if pool != "" {
+		name = cl.Config.ClusterName + "-pool-" + pool
+	}
+	if location != cl.Config.Hcloud.Location {
+		name = name + "-" + location
+	}
+	labels := map[string]string{
+		clusterLabel:  cl.Config.ClusterName,
+		roleLabel:     "worker",
+		locationLabel: location,
+		shardLabel:    strconv.Itoa(shard),
+	}
+	if pool != "" {
+		labels[poolLabel] = pool
+	}
+	return hcloud.PlacementGroupCreateOpts{
+		Name:   fmt.Sprintf("%s-%d", name, shard),
+		Type:   hcloud.PlacementGroupTypeSpread,
+		Labels: labels,
+	}
+}
+
+// ensureWorkerPlacementGroup returns the placement group of the pool in the
+// given location with the fewest servers. Once all are full, a new one is
+// created.
+func ensureWorkerPlacementGroup(cl *cluster.Cluster, pool string, location string) (*hcloud.PlacementGroup, error) {
+	selector := clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=worker," + locationLabel + "=" + location
+	if pool != "" {
+		selector = selector + "," + poolLabel + "=" + pool
+	} else {
+		selector = selector + ",!" + poolLabel
+	}
+	placementGroups, err := cl.Client.PlacementGroup.AllWithOpts(*cl.Ctx, hcloud.PlacementGroupListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: selector,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, shard := selectWorkerPlacementGroup(placementGroups)
+	if result != nil {
+		cl.Logger.Debug.Printf("Using placement group %q with %d servers\n", result.Name, len(result.Servers))
+		return result, nil
+	}
+	placementGroup, _, err := clients.HcloudEnsurePlacementGroup(cl, workerPlacementGroupTemplate(cl, pool, location, shard), true, false)
+	return placementGroup, err
+}
+
+// selectWorkerPlacementGroup returns the placement group with the fewest
+// servers that is not yet full. If all are full, it returns the lowest shard
+// not in use for a new placement group instead.
+func selectWorkerPlacementGroup(placementGroups []*hcloud.PlacementGroup) (*hcloud.PlacementGroup, int) {
+	var result *hcloud.PlacementGroup
+	shards := map[int]bool{}
+	for _, placementGroup := range placementGroups {
+		shard, _ := strconv.Atoi(placementGroup.Labels[shardLabel])
+		shards[shard] = true
+		if len(placementGroup.Servers) >= placementGroupSpreadLimit {
+			continue
+		}
+		if result == nil || len(placementGroup.Servers) < len(result.Servers) {
+			result = placementGroup
+		}
+	}
+	if result != nil {
+		return result, 0
+	}
+	shard := 1
+	for shards[shard] {
+		shard++
+	}
+	return nil, shard
+}
+
+// deleteEmptyWorkerPlacementGroup deletes the worker placement group a
+// deleted server was in, if no other server is left in it.
+func deleteEmptyWorkerPlacementGroup(cl *cluster.Cluster, placementGroupID int) error {
+	placementGroup, _, err := cl.Client.PlacementGroup.GetByID(*cl.Ctx, placementGroupID)
+	if err != nil {
+		return err
+	}
+	if placementGroup == nil || placementGroup.Labels[roleLabel] != "worker" || len(placementGroup.Servers) > 0 {
+		return nil
+	}
+	cl.Logger.Info.Printf("Deleting empty placement group %q\n", placementGroup.Name)
+	_, err = cl.Client.PlacementGroup.Delete(*cl.Ctx, placementGroup)
+	if err != nil {
+		return err
+	}
+	cl.RecordDeleted("placement-group", placementGroup.Name, placementGroup.ID)
+	return nil
+}
+
+// controlplaneLoadBalanacerTemplate balances the Kubernetes and Talos API. With
+// the https health check, API servers that are not ready (according to
+// /readyz) are taken out of the rotation.
 func controlplaneLoadBalanacerTemplate(cl *cluster.Cluster, network *hcloud.Network) hcloud.LoadBalancerCreateOpts {
 	kubernetesApiServerPort := 6443
 	talosApiServerPort := 50000
 	usePrivateIP := true
+	publicInterface := !cl.Config.IsPrivateEndpoint()
+	loadBalancerType := cl.Config.ControlplaneLoadBalancer.Type
+	if loadBalancerType == "" {
+		loadBalancerType = "lb11"
+	}
+	algorithm := hcloud.LoadBalancerAlgorithmTypeRoundRobin
+	if cl.Config.ControlplaneLoadBalancer.Algorithm != "" {
+		algorithm = hcloud.LoadBalancerAlgorithmType(cl.Config.ControlplaneLoadBalancer.Algorithm)
+	}
+	kubernetesApiServerHealthCheck := loadBalancerTCPHealthCheck(kubernetesApiServerPort)
+	if cl.Config.ControlplaneLoadBalancer.HealthCheck == "https" {
+		kubernetesApiServerHealthCheck.Protocol = hcloud.LoadBalancerServiceProtocolHTTP
+		readyzPath := "/readyz"
+		tls := true
+		kubernetesApiServerHealthCheck.HTTP = &hcloud.LoadBalancerCreateOptsServiceHealthCheckHTTP{
+			Path:        &readyzPath,
+			StatusCodes: []string{"2??"},
+			TLS:         &tls,
+		}
+	}
 	return hcloud.LoadBalancerCreateOpts{
 		Name: cl.Config.ClusterName + "-controlplane",
 		LoadBalancerType: &hcloud.LoadBalancerType{
-			Name: "lb11",
+			Name: loadBalancerType,
+		},
+		Algorithm: &hcloud.LoadBalancerAlgorithm{
+			Type: algorithm,
 		},
 		Location: &hcloud.Location{
 			Name: cl.Config.Hcloud.Location,
 		},
-		Network: network,
+		Network:         network,
+		PublicInterface: &publicInterface,
 		Services: []hcloud.LoadBalancerCreateOptsService{
 			{
 				ListenPort:      &kubernetesApiServerPort,
 				DestinationPort: &kubernetesApiServerPort,
 				Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
+				HealthCheck:     kubernetesApiServerHealthCheck,
 			},
 			{
 				ListenPort:      &talosApiServerPort,
 				DestinationPort: &talosApiServerPort,
 				Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
+				HealthCheck:     loadBalancerTCPHealthCheck(talosApiServerPort),
 			},
 		},
 		Targets: []hcloud.LoadBalancerCreateOptsTarget{
@@ -83,37 +280,226 @@ func controlplaneLoadBalanacerTemplate(cl *cluster.Cluster, network *hcloud.Netw
 	}
 }
 
-func nodeFirewallTemplate(cl *cluster.Cluster, network *hcloud.Network) hcloud.FirewallCreateOpts {
-	_, publicIPRange4, _ := net.ParseCIDR("0.0.0.0/0")
-	_, publicIPRange6, _ := net.ParseCIDR("::/0")
-	privateIPRange := network.IPRange
-	anyPort := "any"
-	return hcloud.FirewallCreateOpts{
-		Name: cl.Config.ClusterName + "-nodes",
-		Rules: []hcloud.FirewallRule{
-			{
-				Direction: hcloud.FirewallRuleDirectionIn,
-				Protocol:  hcloud.FirewallRuleProtocolTCP,
-				SourceIPs: []net.IPNet{*privateIPRange},
-				Port:      &anyPort,
-			},
+func controlplaneFloatingIPTemplate(cl *cluster.Cluster) hcloud.FloatingIPCreateOpts {
+	name := cl.Config.ClusterName + "-controlplane"
+	return hcloud.FloatingIPCreateOpts{
+		Name: &name,
+		Type: hcloud.FloatingIPTypeIPv4,
+		HomeLocation: &hcloud.Location{
+			Name: cl.Config.Hcloud.Location,
+		},
+		Labels: map[string]string{clusterLabel: cl.Config.ClusterName},
+	}
+}
+
+// ensureControlplaneEndpoint ensures the load balancer or floating IP the
+// controlplane is reached through and returns its public IPs. The IPv6 is nil
+// for floating IPs. Without public interface the private IP of the load
+// balancer is returned.
+func ensureControlplaneEndpoint(cl *cluster.Cluster, network *hcloud.Network, create bool, update bool) (net.IP, net.IP, error) {
+	if cl.Config.IsFloatingIPEndpoint() {
+		floatingIP, err := clients.HcloudEnsureFloatingIP(cl, controlplaneFloatingIPTemplate(cl), create)
+		if err != nil {
+			return nil, nil, err
+		}
+		return floatingIP.IP, nil, nil
+	}
+	loadBalancer, _, err := clients.HcloudEnsureLoadBalancer(cl, network, controlplaneLoadBalanacerTemplate(cl, network), create, update)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cl.Config.IsPrivateEndpoint() {
+		return loadBalancer.PrivateNet[0].IP, nil, nil
+	}
+	return loadBalancer.PublicNet.IPv4.IP, loadBalancer.PublicNet.IPv6.IP, nil
+}
+
+// ensureControlplaneFloatingIPAssigned assigns the controlplane floating IP to
+// a controlplane node, unless it is already held by one other than the
+// excluded server (e.g. a node that is about to be deleted).
+func ensureControlplaneFloatingIPAssigned(cl *cluster.Cluster, excludeServerID int) error {
+	if !cl.Config.IsFloatingIPEndpoint() {
+		return nil
+	}
+	floatingIP, err := clients.HcloudEnsureFloatingIP(cl, controlplaneFloatingIPTemplate(cl), false)
+	if err != nil {
+		return err
+	}
+	servers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=controlplane",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	candidates := []*hcloud.Server{}
+	for _, server := range servers {
+		if server.ID == excludeServerID {
+			continue
+		}
+		if floatingIP.Server != nil && floatingIP.Server.ID == server.ID {
+			return nil
+		}
+		if server.Status == hcloud.ServerStatusRunning {
+			candidates = append(candidates, server)
+		}
+	}
+	if len(candidates) == 0 {
+		cl.Logger.Warn.Printf("No controlplane node available to hold floating IP %q\n", floatingIP.Name)
+		return nil
+	}
+	return clients.HcloudAssignFloatingIP(cl, floatingIP, candidates[0])
+}
+
+const (
+	ingressHttpNodePort  = 30080
+	ingressHttpsNodePort = 30443
+)
+
+// ingressLoadBalancerTemplate forwards HTTP and HTTPS to the node ports of the
+// ingress controller on the worker nodes (of the ingress pool).
+func ingressLoadBalancerTemplate(cl *cluster.Cluster, network *hcloud.Network) hcloud.LoadBalancerCreateOpts {
+	httpPort := 80
+	httpsPort := 443
+	httpNodePort := ingressHttpNodePort
+	httpsNodePort := ingressHttpsNodePort
+	usePrivateIP := true
+	proxyProtocol := cl.Config.Ingress.ProxyProtocol
+	loadBalancerType := cl.Config.Ingress.LoadBalancerType
+	if loadBalancerType == "" {
+		loadBalancerType = "lb11"
+	}
+	selector := clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=worker"
+	if cl.Config.Ingress.Pool != "" {
+		selector = selector + "," + poolLabel + "=" + cl.Config.Ingress.Pool
+	}
+	return hcloud.LoadBalancerCreateOpts{
+		Name: cl.Config.ClusterName + "-ingress",
+		LoadBalancerType: &hcloud.LoadBalancerType{
+			Name: loadBalancerType,
+		},
+		Algorithm: &hcloud.LoadBalancerAlgorithm{
+			Type: hcloud.LoadBalancerAlgorithmTypeRoundRobin,
+		},
+		Location: &hcloud.Location{
+			Name: cl.Config.Hcloud.Location,
+		},
+		Network: network,
+		Services: []hcloud.LoadBalancerCreateOptsService{
 			{
-				Direction: hcloud.FirewallRuleDirectionIn,
-				Protocol:  hcloud.FirewallRuleProtocolUDP,
-				SourceIPs: []net.IPNet{*privateIPRange},
-				Port:      &anyPort,
+				ListenPort:      &httpPort,
+				DestinationPort: &httpNodePort,
+				Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
+				Proxyprotocol:   &proxyProtocol,
+				HealthCheck:     loadBalancerTCPHealthCheck(httpNodePort),
 			},
 			{
-				Direction: hcloud.FirewallRuleDirectionIn,
-				Protocol:  hcloud.FirewallRuleProtocolICMP,
-				SourceIPs: []net.IPNet{*privateIPRange},
+				ListenPort:      &httpsPort,
+				DestinationPort: &httpsNodePort,
+				Protocol:        hcloud.LoadBalancerServiceProtocolTCP,
+				Proxyprotocol:   &proxyProtocol,
+				HealthCheck:     loadBalancerTCPHealthCheck(httpsNodePort),
 			},
+		},
+		Targets: []hcloud.LoadBalancerCreateOptsTarget{
 			{
-				Direction: hcloud.FirewallRuleDirectionIn,
-				Protocol:  hcloud.FirewallRuleProtocolICMP,
-				SourceIPs: []net.IPNet{*publicIPRange4, *publicIPRange6},
+				Type: hcloud.LoadBalancerTargetTypeLabelSelector,
+				LabelSelector: hcloud.LoadBalancerCreateOptsTargetLabelSelector{
+					Selector: selector,
+				},
+				UsePrivateIP: &usePrivateIP,
 			},
 		},
+		Labels: map[string]string{clusterLabel: cl.Config.ClusterName},
+	}
+}
+
+// loadBalancerTCPHealthCheck is the health check hcloud uses by default.
+func loadBalancerTCPHealthCheck(port int) *hcloud.LoadBalancerCreateOptsServiceHealthCheck {
+	interval := 15 * time.Second
+	timeout := 10 * time.Second
+	retries := 3
+	return &hcloud.LoadBalancerCreateOptsServiceHealthCheck{
+		Protocol: hcloud.LoadBalancerServiceProtocolTCP,
+		Port:     &port,
+		Interval: &interval,
+		Timeout:  &timeout,
+		Retries:  &retries,
+	}
+}
+
+func nodeFirewallName(cl *cluster.Cluster) string {
+	return cl.Config.ClusterName + "-nodes"
+}
+
+// nodeIPv6Ranges returns the public IPv6 ranges of all servers of the cluster
+// on dual-stack clusters, as nodes talk to each other through them.
+func nodeIPv6Ranges(cl *cluster.Cluster) ([]net.IPNet, error) {
+	result := []net.IPNet{}
+	if !cl.Config.Network.DualStack {
+		return result, nil
+	}
+	servers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: clusterLabel + "=" + cl.Config.ClusterName,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		if server.PublicNet.IPv6.Network != nil {
+			result = append(result, *server.PublicNet.IPv6.Network)
+		}
+	}
+	return result, nil
+}
+
+// nodeFirewallTemplate trusts all traffic from the private network and, on
+// dual-stack clusters, from the public IPv6 ranges of the cluster nodes.
+// Configured rules without role or pool are appended.
+func nodeFirewallTemplate(cl *cluster.Cluster, network *hcloud.Network) (hcloud.FirewallCreateOpts, error) {
+	nodeIPv6Ranges, err := nodeIPv6Ranges(cl)
+	if err != nil {
+		return hcloud.FirewallCreateOpts{}, err
+	}
+	_, publicIPRange4, _ := net.ParseCIDR("0.0.0.0/0")
+	_, publicIPRange6, _ := net.ParseCIDR("::/0")
+	trustedIPRanges := append([]net.IPNet{*network.IPRange}, nodeIPv6Ranges...)
+	anyPort := "any"
+	rules := []hcloud.FirewallRule{
+		{
+			Direction: hcloud.FirewallRuleDirectionIn,
+			Protocol:  hcloud.FirewallRuleProtocolTCP,
+			SourceIPs: trustedIPRanges,
+			Port:      &anyPort,
+		},
+		{
+			Direction: hcloud.FirewallRuleDirectionIn,
+			Protocol:  hcloud.FirewallRuleProtocolUDP,
+			SourceIPs: trustedIPRanges,
+			Port:      &anyPort,
+		},
+		{
+			Direction: hcloud.FirewallRuleDirectionIn,
+			Protocol:  hcloud.FirewallRuleProtocolICMP,
+			SourceIPs: trustedIPRanges,
+		},
+		{
+			Direction: hcloud.FirewallRuleDirectionIn,
+			Protocol:  hcloud.FirewallRuleProtocolICMP,
+			SourceIPs: []net.IPNet{*publicIPRange4, *publicIPRange6},
+		},
+	}
+	for _, rule := range cl.Config.Firewall.Rules {
+		if rule.Role == "" && rule.Pool == "" {
+			rules = append(rules, firewallRuleTemplate(rule))
+		}
+	}
+	return hcloud.FirewallCreateOpts{
+		Name:  nodeFirewallName(cl),
+		Rules: rules,
 		ApplyTo: []hcloud.FirewallResource{
 			{
 				Type: hcloud.FirewallResourceTypeLabelSelector,
@@ -121,32 +507,316 @@ func nodeFirewallTemplate(cl *cluster.Cluster, network *hcloud.Network) hcloud.F
 					Selector: clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel,
 				},
 			},
+			{
+				Type: hcloud.FirewallResourceTypeLabelSelector,
+				LabelSelector: &hcloud.FirewallResourceLabelSelector{
+					Selector: clusterLabel + "=" + cl.Config.ClusterName + "," + natLabel,
+				},
+			},
 		},
 		Labels: map[string]string{clusterLabel: cl.Config.ClusterName},
+	}, nil
+}
+
+// scopedFirewallTemplates returns one firewall per role or pool that
+// configured rules are scoped to. They complement the node firewall, as
+// hcloud allows traffic if any firewall applied to a server allows it.
+func scopedFirewallTemplates(cl *cluster.Cluster) []hcloud.FirewallCreateOpts {
+	rules := cl.Config.Firewall.Rules
+	// without load balancer the APIs are reached on the public interface of
+	// the controlplane node holding the floating IP
+	if cl.Config.IsFloatingIPEndpoint() {
+		rules = append([]cluster.ConfigFirewallRule{
+			{Description: "kubernetes api", Protocol: "tcp", Port: "6443", SourceIPs: []string{"0.0.0.0/0", "::/0"}, Role: "controlplane"},
+			{Description: "talos api", Protocol: "tcp", Port: "50000", SourceIPs: []string{"0.0.0.0/0", "::/0"}, Role: "controlplane"},
+		}, rules...)
+	}
+	tmpls := []hcloud.FirewallCreateOpts{}
+	tmplIndices := map[string]int{}
+	for _, rule := range rules {
+		if rule.Role == "" && rule.Pool == "" {
+			continue
+		}
+		name := cl.Config.ClusterName + "-" + rule.Role
+		selector := clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=" + rule.Role
+		if rule.Pool != "" {
+			name = cl.Config.ClusterName + "-pool-" + rule.Pool
+			selector = clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=worker," + poolLabel + "=" + rule.Pool
+		}
+		i, ok := tmplIndices[name]
+		if !ok {
+			i = len(tmpls)
+			tmplIndices[name] = i
+			tmpls = append(tmpls, hcloud.FirewallCreateOpts{
+				Name: name,
+				ApplyTo: []hcloud.FirewallResource{
+					{
+						Type: hcloud.FirewallResourceTypeLabelSelector,
+						LabelSelector: &hcloud.FirewallResourceLabelSelector{
+							Selector: selector,
+						},
+					},
+				},
+				Labels: map[string]string{clusterLabel: cl.Config.ClusterName, firewallLabel: "scoped"},
+			})
+		}
+		tmpls[i].Rules = append(tmpls[i].Rules, firewallRuleTemplate(rule))
+	}
+	return tmpls
+}
+
+func firewallRuleTemplate(rule cluster.ConfigFirewallRule) hcloud.FirewallRule {
+	sourceIPs := []net.IPNet{}
+	for _, sourceIP := range rule.SourceIPs {
+		_, sourceIPNet, _ := net.ParseCIDR(sourceIP)
+		sourceIPs = append(sourceIPs, *sourceIPNet)
 	}
+	result := hcloud.FirewallRule{
+		Direction: hcloud.FirewallRuleDirectionIn,
+		Protocol:  hcloud.FirewallRuleProtocol(rule.Protocol),
+		SourceIPs: sourceIPs,
+	}
+	if rule.Port != "" {
+		port := rule.Port
+		result.Port = &port
+	}
+	if rule.Description != "" {
+		description := rule.Description
+		result.Description = &description
+	}
+	return result
+}
+
+// natGatewayTemplate is a plain debian server that masquerades all traffic
+// from the private network leaving through its public interface. It does not
+// carry the role label, so it is never mistaken for a Talos node.
+func natGatewayTemplate(cl *cluster.Cluster, network *hcloud.Network) hcloud.ServerCreateOpts {
+	serverType := cl.Config.Network.NatGatewayType
+	if serverType == "" {
+		serverType = "cx22"
+	}
+	userData := fmt.Sprintf(`#cloud-config
+write_files:
+  - path: /etc/sysctl.d/99-nat-gateway.conf
+    content: |
+      net.ipv4.ip_forward=1
+  - path: /etc/nftables.conf
+    content: |
+      #!/usr/sbin/nft -f
+      flush ruleset
+      table ip nat {
+        chain postrouting {
+          type nat hook postrouting priority srcnat;
+          ip saddr %s oifname "eth0" masquerade
+        }
+      }
+runcmd:
+  - sysctl --system
+  - systemctl enable --now nftables
+`, network.IPRange.String())
+	startAfterCreate := true
+	return hcloud.ServerCreateOpts{
+		Name:       cl.Config.ClusterName + "-nat-gateway",
+		ServerType: &hcloud.ServerType{Name: serverType},
+		Image:      &hcloud.Image{Name: "debian-12"},
+		Location: &hcloud.Location{
+			Name: cl.Config.Hcloud.Location,
+		},
+		Networks:         []*hcloud.Network{network},
+		StartAfterCreate: &startAfterCreate,
+		UserData:         userData,
+		Labels:           map[string]string{clusterLabel: cl.Config.ClusterName, natLabel: "true"},
+	}
+}
+
+// ensureNatGateway ensures the NAT gateway server and the default route of the
+// network through it.
+func ensureNatGateway(cl *cluster.Cluster, network *hcloud.Network, create bool) (*hcloud.Server, error) {
+	server, err := clients.HcloudEnsureServer(cl, natGatewayTemplate(cl, network), create)
+	if err != nil {
+		return nil, err
+	}
+	_, defaultRoute, _ := net.ParseCIDR("0.0.0.0/0")
+	err = clients.HcloudEnsureNetworkRoute(cl, network, hcloud.NetworkRoute{
+		Destination: defaultRoute,
+		Gateway:     server.PrivateNet[0].IP,
+	}, create)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
 }
 
 func nodeName(cl *cluster.Cluster, name string) string {
 	return cl.Config.ClusterName + "-" + strings.Replace(name, "%id%", utils.RandString(6), 1)
 }
 
-func controlplaneNodeTemplate(cl *cluster.Cluster, serverType string, name string, talosVersion string) (clients.HcloudServerCreateFromImageOpts, error) {
-	userData, err := os.ReadFile(path.Join(cl.Dir, "controlplane.yaml"))
+// nodeLocation picks the location of the pool with the fewest servers of the
+// given role and pool, so that nodes are spread evenly across locations.
+func nodeLocation(cl *cluster.Cluster, role string, pool cluster.ConfigPool) (string, error) {
+	locations := cl.Config.PoolLocations(pool)
+	err := clients.HcloudValidateLocations(cl, locations)
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 1 {
+		return locations[0], nil
+	}
+	selector := clusterLabel + "=" + cl.Config.ClusterName + "," + roleLabel + "=" + role
+	if pool.Name != "" {
+		selector = selector + "," + poolLabel + "=" + pool.Name
+	}
+	servers, err := cl.Client.Server.AllWithOpts(*cl.Ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			LabelSelector: selector,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	counts := map[string]int{}
+	for _, server := range servers {
+		if server.Datacenter != nil && server.Datacenter.Location != nil {
+			counts[server.Datacenter.Location.Name]++
+		}
+	}
+	result := locations[0]
+	for _, location := range locations[1:] {
+		if counts[location] < counts[result] {
+			result = location
+		}
+	}
+	return result, nil
+}
+
+// nodeConfigTemplate renders the machine config of a node. The location is
+// set as region label, matching the label the hcloud cloud controller manager
+// sets, so that workloads can be spread across locations.
+func nodeConfigTemplate(cl *cluster.Cluster, serverName string, role string, pool cluster.ConfigPool, location string) (string, error) {
+	configFile := "worker.yaml"
+	if role == "controlplane" {
+		configFile = "controlplane.yaml"
+	}
+
+	nodeLabels := map[string]string{}
+	for k, v := range pool.Labels {
+		nodeLabels[k] = v
+	}
+	nodeLabels[roleLabel] = role
+	if pool.Name != "" {
+		nodeLabels[poolLabel] = pool.Name
+	}
+	if location != "" {
+		nodeLabels[regionLabel] = location
+	}
+	nodeTaints := map[string]string{}
+	for _, taint := range pool.Taints {
+		nodeTaints[taint.Key] = taint.Value + ":" + taint.Effect
+	}
+
+	machineNetworkPatch := map[string]interface{}{
+		"hostname": serverName,
+	}
+	interfaces := []interface{}{}
+	// the floating IP is configured on all controlplane nodes, so that
+	// whichever holds it accepts traffic for it
+	if cl.Config.IsFloatingIPEndpoint() && role == "controlplane" {
+		floatingIP, err := clients.HcloudEnsureFloatingIP(cl, controlplaneFloatingIPTemplate(cl), false)
+		if err != nil {
+			return "", err
+		}
+		interfaces = append(interfaces, map[string]interface{}{
+			"deviceSelector": nodeInterfaceSelector(true),
+			"dhcp":           true,
+			"addresses":      []string{floatingIP.IP.String() + "/32"},
+		})
+	}
+	// nodes without public IPv4 send their egress traffic to the network
+	// gateway, which forwards it to the NAT gateway
+	if cl.Config.Network.NatGateway && !pool.HasPublicIPv4() {
+		interfaces = append(interfaces, map[string]interface{}{
+			"deviceSelector": nodeInterfaceSelector(false),
+			"dhcp":           true,
+			"routes": []interface{}{
+				map[string]interface{}{
+					"network": "0.0.0.0/0",
+					"gateway": cl.Config.Network.Gateway().String(),
+				},
+			},
+		})
+	}
+	if len(interfaces) > 0 {
+		machineNetworkPatch["interfaces"] = interfaces
+	}
+	machinePatch := map[string]interface{}{
+		"network":    machineNetworkPatch,
+		"nodeLabels": nodeLabels,
+	}
+	if len(nodeTaints) > 0 {
+		machinePatch["nodeTaints"] = nodeTaints
+	}
+	patch, err := yaml.Marshal(map[string]interface{}{
+		"machine": machinePatch,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return TalosPatchConfig(cl, configFile, string(patch))
+}
+
+// nodeInterfaceSelector selects the public or the private interface of a
+// node by its MAC address, as the interface names depend on the image and on
+// which interfaces the server has. hcloud hands out MAC addresses starting
+// with 96:00 for public and with 86:00:00 for private interfaces.
+func nodeInterfaceSelector(public bool) map[string]interface{} {
+	if public {
+		return map[string]interface{}{"hardwareAddr": "96:00:*"}
+	}
+	return map[string]interface{}{"hardwareAddr": "86:00:00:*"}
+}
+
+// validatePool validates the pool before any resource is created for its
+// nodes and warns about nodes that will lack egress.
+func validatePool(cl *cluster.Cluster, pool cluster.ConfigPool) error {
+	if err := pool.Validate(); err != nil {
+		return err
+	}
+	if cl.Config.Network.NatGateway || pool.HasPublicIPv4() {
+		return nil
+	}
+	if pool.HasPublicIPv6() {
+		cl.Logger.Warn.Printf("Nodes with only public IPv6 cannot reach IPv4-only hosts (like some container registries) unless the network routes it through a NAT gateway\n")
+	} else {
+		cl.Logger.Warn.Printf("Nodes without public IP have no egress unless the network routes it through a NAT gateway\n")
+	}
+	return nil
+}
+
+func controlplaneNodeTemplate(cl *cluster.Cluster, serverType string, location string, name string, talosVersion string) (clients.HcloudServerCreateFromImageOpts, error) {
+	serverName := nodeName(cl, name)
+	userData, err := nodeConfigTemplate(cl, serverName, "controlplane", cl.Config.Controlplane, location)
 	if err != nil {
 		return clients.HcloudServerCreateFromImageOpts{}, err
 	}
 	return clients.HcloudServerCreateFromImageOpts{
-		Name:           nodeName(cl, name),
+		Name:           serverName,
 		ServerType:     serverType,
-		UserData:       string(userData),
+		UserData:       userData,
 		BaseLabels:     map[string]string{clusterLabel: cl.Config.ClusterName},
 		FinalizeLabels: map[string]string{roleLabel: "controlplane"},
 		ImageTarXzUrl:  fmt.Sprintf("https://factory.talos.dev/image/376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba/v%s/hcloud-amd64.raw.xz", talosVersion),
+		ImageSnapshot:  cl.Config.Controlplane.ImageSnapshot,
+		Location:       location,
+		PublicIPv4:     cl.Config.Controlplane.HasPublicIPv4(),
+		PublicIPv6:     cl.Config.Controlplane.HasPublicIPv6(),
 	}, nil
 }
 
-func workerNodeTemplate(cl *cluster.Cluster, serverType string, pool string, name string, talosVersion string) (clients.HcloudServerCreateFromImageOpts, error) {
-	userData, err := os.ReadFile(path.Join(cl.Dir, "worker.yaml"))
+func workerNodeTemplate(cl *cluster.Cluster, serverType string, location string, pool string, name string, talosVersion string) (clients.HcloudServerCreateFromImageOpts, error) {
+	configPool := cl.Config.FindPool(pool)
+	serverName := nodeName(cl, name)
+	userData, err := nodeConfigTemplate(cl, serverName, "worker", configPool, location)
 	if err != nil {
 		return clients.HcloudServerCreateFromImageOpts{}, err
 	}
@@ -155,11 +825,150 @@ func workerNodeTemplate(cl *cluster.Cluster, serverType string, pool string, nam
 		finalizeLabels[poolLabel] = pool
 	}
 	return clients.HcloudServerCreateFromImageOpts{
-		Name:           nodeName(cl, name),
+		Name:           serverName,
 		ServerType:     serverType,
-		UserData:       string(userData),
+		UserData:       userData,
 		BaseLabels:     map[string]string{clusterLabel: cl.Config.ClusterName},
 		FinalizeLabels: finalizeLabels,
 		ImageTarXzUrl:  fmt.Sprintf("https://factory.talos.dev/image/376567988ad370138ad8b2698212367b8edcb69b5fd68c80be1f2ec7d603b4ba/v%s/hcloud-amd64.raw.xz", talosVersion),
+		ImageSnapshot:  configPool.ImageSnapshot,
+		Location:       location,
+		PublicIPv4:     configPool.HasPublicIPv4(),
+		PublicIPv6:     configPool.HasPublicIPv6(),
 	}, nil
 }
+
+// bastionTemplate is a plain debian server running WireGuard with a single
+// peer. Traffic of the peer is masqueraded, so it reaches the private network
+// with the private IP of the bastion and needs no network route.
+func bastionTemplate(cl *cluster.Cluster, network *hcloud.Network) (hcloud.ServerCreateOpts, error) {
+	serverType := cl.Config.Bastion.ServerType
+	if serverType == "" {
+		serverType = "cx22"
+	}
+	_, subnet, _ := net.ParseCIDR(cl.Config.Bastion.Subnet)
+	subnetBits, _ := subnet.Mask.Size()
+	bastionIP, clientIP := cl.Config.Bastion.Addresses()
+	clientPublicKey, err := utils.WireguardPublicKey(cl.Config.Bastion.ClientPrivateKey)
+	if err != nil {
+		return hcloud.ServerCreateOpts{}, err
+	}
+	userData := fmt.Sprintf(`#cloud-config
+packages:
+  - wireguard
+  - nftables
+write_files:
+  - path: /etc/sysctl.d/99-bastion.conf
+    content: |
+      net.ipv4.ip_forward=1
+  - path: /etc/wireguard/wg0.conf
+    permissions: "0600"
+    content: |
+      [Interface]
+      Address = %s/%d
+      ListenPort = %d
+      PrivateKey = %s
+
+      [Peer]
+      PublicKey = %s
+      AllowedIPs = %s/32
+  - path: /etc/nftables.conf
+    content: |
+      #!/usr/sbin/nft -f
+      flush ruleset
+      table ip nat {
+        chain postrouting {
+          type nat hook postrouting priority srcnat;
+          ip saddr %s ip daddr %s masquerade
+        }
+      }
+runcmd:
+  - sysctl --system
+  - systemctl enable --now nftables
+  - systemctl enable --now wg-quick@wg0
+`, bastionIP, subnetBits, cl.Config.Bastion.Port, cl.Config.Bastion.PrivateKey, clientPublicKey, clientIP, subnet.String(), network.IPRange.String())
+	startAfterCreate := true
+	return hcloud.ServerCreateOpts{
+		Name:       cl.Config.ClusterName + "-bastion",
+		ServerType: &hcloud.ServerType{Name: serverType},
+		Image:      &hcloud.Image{Name: "debian-12"},
+		Location: &hcloud.Location{
+			Name: cl.Config.Hcloud.Location,
+		},
+		Networks:         []*hcloud.Network{network},
+		StartAfterCreate: &startAfterCreate,
+		UserData:         userData,
+		Labels:           map[string]string{clusterLabel: cl.Config.ClusterName, bastionLabel: "true"},
+	}, nil
+}
+
+// bastionFirewallTemplate only opens the WireGuard port of the bastion.
+func bastionFirewallTemplate(cl *cluster.Cluster) hcloud.FirewallCreateOpts {
+	_, publicIPRange4, _ := net.ParseCIDR("0.0.0.0/0")
+	_, publicIPRange6, _ := net.ParseCIDR("::/0")
+	port := fmt.Sprintf("%d", cl.Config.Bastion.Port)
+	return hcloud.FirewallCreateOpts{
+		Name: cl.Config.ClusterName + "-bastion",
+		Rules: []hcloud.FirewallRule{
+			{
+				Direction: hcloud.FirewallRuleDirectionIn,
+				Protocol:  hcloud.FirewallRuleProtocolUDP,
+				SourceIPs: []net.IPNet{*publicIPRange4, *publicIPRange6},
+				Port:      &port,
+			},
+			{
+				Direction: hcloud.FirewallRuleDirectionIn,
+				Protocol:  hcloud.FirewallRuleProtocolICMP,
+				SourceIPs: []net.IPNet{*publicIPRange4, *publicIPRange6},
+			},
+		},
+		ApplyTo: []hcloud.FirewallResource{
+			{
+				Type: hcloud.FirewallResourceTypeLabelSelector,
+				LabelSelector: &hcloud.FirewallResourceLabelSelector{
+					Selector: clusterLabel + "=" + cl.Config.ClusterName + "," + bastionLabel,
+				},
+			},
+		},
+		Labels: map[string]string{clusterLabel: cl.Config.ClusterName},
+	}
+}
+
+// ensureBastion ensures the bastion server and its firewall and (re)writes
+// the WireGuard client configuration to wireguard.conf. It returns the number
+// of differences of the firewall left.
+func ensureBastion(cl *cluster.Cluster, network *hcloud.Network, create bool, update bool) (*hcloud.Server, int, error) {
+	_, drifts, err := clients.HcloudEnsureFirewall(cl, bastionFirewallTemplate(cl), create, update)
+	if err != nil {
+		return nil, drifts, err
+	}
+	tmpl, err := bastionTemplate(cl, network)
+	if err != nil {
+		return nil, drifts, err
+	}
+	server, err := clients.HcloudEnsureServer(cl, tmpl, create)
+	if err != nil {
+		return nil, drifts, err
+	}
+
+	_, clientIP := cl.Config.Bastion.Addresses()
+	bastionPublicKey, err := utils.WireguardPublicKey(cl.Config.Bastion.PrivateKey)
+	if err != nil {
+		return nil, drifts, err
+	}
+	clientConfig := fmt.Sprintf(`[Interface]
+Address = %s/32
+PrivateKey = %s
+
+[Peer]
+PublicKey = %s
+Endpoint = %s:%d
+AllowedIPs = %s
+PersistentKeepalive = 25
+`, clientIP, cl.Config.Bastion.ClientPrivateKey, bastionPublicKey, server.PublicNet.IPv4.IP, cl.Config.Bastion.Port, network.IPRange.String())
+	err = os.WriteFile(path.Join(cl.Dir, "wireguard.conf"), []byte(clientConfig), 0o600)
+	if err != nil {
+		return nil, drifts, err
+	}
+	return server, drifts, nil
+}
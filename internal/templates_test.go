@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+func TestSelectWorkerPlacementGroup(t *testing.T) {
+	placementGroup := func(shard int, servers int) *hcloud.PlacementGroup {
+		return &hcloud.PlacementGroup{
+			Name:    "workers-" + strconv.Itoa(shard),
+			Labels:  map[string]string{shardLabel: strconv.Itoa(shard)},
+			Servers: make([]int, servers),
+		}
+	}
+	tests := []struct {
+		name            string
+		placementGroups []*hcloud.PlacementGroup
+		expectedName    string
+		expectedShard   int
+	}{
+		{
+			name:          "none",
+			expectedShard: 1,
+		},
+		{
+			name:            "empty group",
+			placementGroups: []*hcloud.PlacementGroup{placementGroup(1, 0)},
+			expectedName:    "workers-1",
+		},
+		{
+			name:            "fewest servers",
+			placementGroups: []*hcloud.PlacementGroup{placementGroup(1, 7), placementGroup(2, 3), placementGroup(3, 5)},
+			expectedName:    "workers-2",
+		},
+		{
+			name:            "full groups are skipped",
+			placementGroups: []*hcloud.PlacementGroup{placementGroup(1, placementGroupSpreadLimit), placementGroup(2, placementGroupSpreadLimit-1)},
+			expectedName:    "workers-2",
+		},
+		{
+			name:            "all full",
+			placementGroups: []*hcloud.PlacementGroup{placementGroup(1, placementGroupSpreadLimit), placementGroup(2, placementGroupSpreadLimit)},
+			expectedShard:   3,
+		},
+		{
+			name:            "all full with gap",
+			placementGroups: []*hcloud.PlacementGroup{placementGroup(3, placementGroupSpreadLimit), placementGroup(1, placementGroupSpreadLimit)},
+			expectedShard:   2,
+		},
+	}
+	for _, test := range tests {
+		actual, shard := selectWorkerPlacementGroup(test.placementGroups)
+		actualName := ""
+		if actual != nil {
+			actualName = actual.Name
+		}
+		if actualName != test.expectedName || shard != test.expectedShard {
+			t.Errorf("%s: selectWorkerPlacementGroup = %q, %d, expected %q, %d", test.name, actualName, shard, test.expectedName, test.expectedShard)
+		}
+	}
+}
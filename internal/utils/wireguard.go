@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+)
+
+func WireguardGenerateKey() (string, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()), nil
+}
+
+func WireguardPublicKey(privateKey string) (string, error) {
+	privateKeyBytes, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", err
+	}
+	key, err := ecdh.X25519().NewPrivateKey(privateKeyBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}